@@ -13,6 +13,51 @@ if [ ! -f "$ENV_FILE" ]; then
     exit 1
 fi
 
+# The config files contain ${VAR} references filled in from .env. The mediastack
+# CLI renders them; without it, envsubst fills in the variables set in .env only
+env_value() { grep -E "^$1=" "$ENV_FILE" | tail -n 1 | cut -d '=' -f2- | sed -E 's/[[:space:]]+#.*$//' | xargs | tr -d '\r'; }
+
+if command -v mediastack > /dev/null 2>&1; then
+    render_file() { mediastack -c "$FOLDER_FOR_YAMLS" config render "$1"; }
+elif command -v envsubst > /dev/null 2>&1; then
+    echo "⚠️  The mediastack CLI was not found in PATH, rendering the yaml config files with envsubst"
+    ENV_VARS=$(grep -oE '^[A-Za-z_][A-Za-z0-9_]*=' "$ENV_FILE" | tr -d '=' | sort -u)
+    render_file() {
+        local var missing=0
+        while IFS= read -r var; do
+            if ! [[ "$var" =~ ^[A-Za-z_][A-Za-z0-9_]*$ ]]; then
+                echo "❌ Error: $1 uses \${$var}, which needs the mediastack CLI" >&2
+                missing=1
+            elif ! grep -qE "^$var=" "$ENV_FILE"; then
+                echo "❌ Error: $1 uses \${$var}, which is not set in $ENV_FILE" >&2
+                missing=1
+            fi
+        done < <(grep -vE '^[[:space:]]*#' "$1" | sed 's/\$\$//g' | grep -oE '\$\{[^}]*\}' | sed -E 's/^\$\{(.*)\}$/\1/' | sort -u || true)
+        [ $missing -eq 0 ] || return 1
+
+        # As with the mediastack CLI: comment lines are copied as they are,
+        # $$ is a literal $ and a $ not followed by { is left alone
+        (
+            for var in $ENV_VARS; do export "$var=$(env_value "$var")"; done
+            sed -e '/^[[:space:]]*#/ s/\$/\x01/g' \
+                -e '/^[[:space:]]*#/! { s/\$\$/\x01/g; s/\$\([^{]\)/\x01\1/g; s/\$$/\x01/ }' "$1" \
+                | envsubst "$(printf '${%s} ' $ENV_VARS)" | sed 's/\x01/$/g'
+        )
+    }
+else
+    echo "❌ Error: neither the mediastack CLI nor envsubst was found in PATH"
+    echo "One of them is needed to render the yaml config files with your .env values:"
+    echo "   - install the mediastack CLI, see cli/README.md"
+    echo "   - or install envsubst, e.g. sudo apt install gettext-base"
+    exit 1
+fi
+render() { render_file "$1" | sudo tee "$2" > /dev/null; }
+
+# Check every config file renders before anything is stopped
+for file in headplane-config.yaml headscale-config.yaml traefik-static.yaml traefik-dynamic.yaml traefik-internal.yaml crowdsec-acquis.yaml; do
+    render_file "$file" > /dev/null
+done
+
 # Read values from .env and clean them
 FOLDER_FOR_MEDIA=$(grep -E '^FOLDER_FOR_MEDIA=' "$ENV_FILE" | cut -d '=' -f2- | xargs | tr -d '\r')
 FOLDER_FOR_DATA=$(grep  -E '^FOLDER_FOR_DATA='  "$ENV_FILE" | cut -d '=' -f2- | xargs | tr -d '\r')
@@ -83,12 +128,12 @@ sudo chmod 664                .env *yaml
 sudo chown $PUID:$PGID        .env *yaml *sh
 sudo touch                    $FOLDER_FOR_DATA/traefik/letsencrypt/acme.json
 sudo chmod 600                $FOLDER_FOR_DATA/traefik/letsencrypt/acme.json && echo "Permissions set to 600 on certs file $FOLDER_FOR_DATA/traefik/letsencrypt/acme.json"
-sudo cp headplane-config.yaml $FOLDER_FOR_DATA/headplane/config.yaml         && echo "File headplane-config.yaml copied to $FOLDER_FOR_DATA/headplane/config.yaml"
-sudo cp headscale-config.yaml $FOLDER_FOR_DATA/headscale/config.yaml         && echo "File headscale-config.yaml copied to $FOLDER_FOR_DATA/headscale/config.yaml"
-sudo cp traefik-static.yaml   $FOLDER_FOR_DATA/traefik/traefik.yaml          && echo "File traefik-static.yaml   copied to $FOLDER_FOR_DATA/traefik/traefik.yaml"
-sudo cp traefik-dynamic.yaml  $FOLDER_FOR_DATA/traefik/dynamic.yaml          && echo "File traefik-dynamic.yaml  copied to $FOLDER_FOR_DATA/traefik/dynamic.yaml"
-sudo cp traefik-internal.yaml $FOLDER_FOR_DATA/traefik/internal.yaml         && echo "File traefik-internal.yaml copied to $FOLDER_FOR_DATA/traefik/internal.yaml"
-sudo cp crowdsec-acquis.yaml  $FOLDER_FOR_DATA/crowdsec/acquis.yaml          && echo "File crowdsec-acquis.yaml  copied to $FOLDER_FOR_DATA/crowdsec/acquis.yaml"
+render headplane-config.yaml  $FOLDER_FOR_DATA/headplane/config.yaml        && echo "File headplane-config.yaml rendered to $FOLDER_FOR_DATA/headplane/config.yaml"
+render headscale-config.yaml  $FOLDER_FOR_DATA/headscale/config.yaml        && echo "File headscale-config.yaml rendered to $FOLDER_FOR_DATA/headscale/config.yaml"
+render traefik-static.yaml    $FOLDER_FOR_DATA/traefik/traefik.yaml         && echo "File traefik-static.yaml   rendered to $FOLDER_FOR_DATA/traefik/traefik.yaml"
+render traefik-dynamic.yaml   $FOLDER_FOR_DATA/traefik/dynamic.yaml         && echo "File traefik-dynamic.yaml  rendered to $FOLDER_FOR_DATA/traefik/dynamic.yaml"
+render traefik-internal.yaml  $FOLDER_FOR_DATA/traefik/internal.yaml        && echo "File traefik-internal.yaml rendered to $FOLDER_FOR_DATA/traefik/internal.yaml"
+render crowdsec-acquis.yaml   $FOLDER_FOR_DATA/crowdsec/acquis.yaml         && echo "File crowdsec-acquis.yaml  rendered to $FOLDER_FOR_DATA/crowdsec/acquis.yaml"
 
 # Subroutine below will check if Docker successully started all containers, before pruning un-used images from Docker
 echo 
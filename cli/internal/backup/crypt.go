@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+// EncryptedExt is appended to the names of age-encrypted uploads
+const EncryptedExt = ".age"
+
+// ParseRecipients parses age recipients. Each spec is an age public key
+// (age1...), an SSH public key, or the path of a file with one of those
+// per line.
+func ParseRecipients(specs []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		if r, err := parseRecipient(spec); err == nil {
+			recipients = append(recipients, r)
+			continue
+		}
+
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an age or SSH public key or a readable recipients file", spec)
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			r, err := parseRecipient(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", spec, i+1, err)
+			}
+			recipients = append(recipients, r)
+		}
+	}
+	return recipients, nil
+}
+
+// parseRecipient parses a single age or SSH public key
+func parseRecipient(s string) (age.Recipient, error) {
+	if strings.HasPrefix(s, "age1") {
+		return age.ParseX25519Recipient(s)
+	}
+	if strings.HasPrefix(s, "ssh-") {
+		return agessh.ParseRecipient(s)
+	}
+	return nil, fmt.Errorf("unknown recipient type %q", s)
+}
+
+// ParseIdentities reads the age identities or SSH private key in each file
+func ParseIdentities(paths []string) ([]age.Identity, error) {
+	var identities []age.Identity
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Contains(data, []byte("PRIVATE KEY")) {
+			id, err := agessh.ParseIdentity(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			identities = append(identities, id)
+			continue
+		}
+
+		ids, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		identities = append(identities, ids...)
+	}
+	return identities, nil
+}
+
+// EncryptFile writes src encrypted to recipients into dst
+func EncryptFile(src, dst string, recipients []age.Recipient) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w, err := age.Encrypt(out, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// DecryptFile writes src decrypted with identities into dst
+func DecryptFile(src, dst string, identities []age.Identity) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", src, err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultExcludes are caches, transcodes and logs the services recreate on
+// their own, which would otherwise make up most of a snapshot
+var DefaultExcludes = []string{
+	"**/cache",
+	"**/Cache",
+	"**/caches",
+	"**/Caches",
+	"**/transcodes",
+	"**/Transcode",
+	"**/logs",
+	"**/Logs",
+	"**/log",
+	"**/Crash Reports",
+	"*.log",
+	"*.log.[0-9]*",
+	"*.pid",
+	"data/logs",
+	"data/plex/Library/Application Support/Plex Media Server/Media",
+}
+
+// Excludes matches archive paths against exclude rules. A rule without a
+// slash matches the final path element anywhere; otherwise it is matched
+// against the whole archive path, e.g. data/sonarr/MediaCover, where **
+// matches any number of path elements. Excluding a directory excludes
+// everything below it.
+type Excludes struct {
+	rules []string
+}
+
+// NewExcludes returns a matcher for rules
+func NewExcludes(rules []string) *Excludes {
+	var clean []string
+	for _, r := range rules {
+		r = strings.Trim(strings.TrimSpace(r), "/")
+		if r != "" {
+			clean = append(clean, r)
+		}
+	}
+	return &Excludes{rules: clean}
+}
+
+// Rules returns the rules of the matcher
+func (e *Excludes) Rules() []string {
+	if e == nil {
+		return nil
+	}
+	return e.rules
+}
+
+// Match reports whether the archive path name is excluded
+func (e *Excludes) Match(name string) bool {
+	if e == nil {
+		return false
+	}
+	for _, rule := range e.rules {
+		if !strings.Contains(rule, "/") {
+			if ok, _ := path.Match(rule, path.Base(name)); ok {
+				return true
+			}
+			continue
+		}
+		if matchSegments(strings.Split(rule, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path elements against a pattern split on slashes
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,52 @@
+package backup
+
+import "testing"
+
+func TestExcludesMatch(t *testing.T) {
+	tests := []struct {
+		rules []string
+		name  string
+		want  bool
+	}{
+		// Rules without a slash match the final path element anywhere
+		{[]string{"*.log"}, "data/sonarr/logs.txt", false},
+		{[]string{"*.log"}, "data/sonarr/sonarr.log", true},
+		{[]string{"*.log"}, "sonarr.log", true},
+		{[]string{"*.log.[0-9]*"}, "data/sonarr/sonarr.log.1", true},
+		{[]string{"cache"}, "data/plex/cache", true},
+		{[]string{"cache"}, "data/plex/cache/file", false},
+		{[]string{"Cache"}, "data/plex/cache", false},
+
+		// Rules with a slash match the whole archive path
+		{[]string{"data/logs"}, "data/logs", true},
+		{[]string{"data/logs"}, "config/data/logs", false},
+		{[]string{"data/*/MediaCover"}, "data/sonarr/MediaCover", true},
+		{[]string{"data/*/MediaCover"}, "data/sonarr/x/MediaCover", false},
+		{[]string{"/data/logs/"}, "data/logs", true},
+
+		// ** matches any number of path elements, including none
+		{[]string{"**/cache"}, "cache", true},
+		{[]string{"**/cache"}, "data/plex/cache", true},
+		{[]string{"**/cache"}, "data/plex/cache.db", false},
+		{[]string{"data/**/Media"}, "data/Media", true},
+		{[]string{"data/**/Media"}, "data/plex/Library/Media", true},
+		{[]string{"data/**/Media"}, "config/plex/Media", false},
+		{[]string{"data/**"}, "data/plex/file", true},
+
+		// Any rule matching excludes the path
+		{[]string{"*.pid", "**/logs"}, "data/radarr/logs", true},
+		{[]string{"", "  "}, "data/radarr/logs", false},
+		{nil, "data/radarr/logs", false},
+	}
+
+	for _, tt := range tests {
+		if got := NewExcludes(tt.rules).Match(tt.name); got != tt.want {
+			t.Errorf("NewExcludes(%q).Match(%q) = %v, want %v", tt.rules, tt.name, got, tt.want)
+		}
+	}
+
+	var nilExcludes *Excludes
+	if nilExcludes.Match("data/sonarr") {
+		t.Error("nil Excludes matched")
+	}
+}
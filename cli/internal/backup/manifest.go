@@ -0,0 +1,192 @@
+// Package backup creates and restores snapshots of the stack's service data
+// and configuration
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestVersion is the manifest format written by this version
+	ManifestVersion = 1
+
+	// manifestEntry is the last entry of every snapshot archive
+	manifestEntry = "manifest.json"
+
+	// ArchiveExt and ManifestExt are appended to a snapshot's ID to name
+	// the archive and the manifest stored alongside it
+	ArchiveExt  = ".tar.gz"
+	ManifestExt = ".manifest.json"
+
+	// Archive path prefixes of the config directory and service data
+	ConfigPrefix = "config"
+	DataPrefix   = "data"
+)
+
+// Manifest describes the contents of a snapshot. It is written both as the
+// last entry of the archive and next to it, so snapshots can be listed
+// without reading the archives.
+type Manifest struct {
+	Version    int         `json:"version"`
+	ID         string      `json:"id"`
+	Created    time.Time   `json:"created"`
+	Host       string      `json:"host"`
+	Project    string      `json:"project"`
+	Variant    string      `json:"variant"`
+	Consistent bool        `json:"consistent"`
+	ConfigDir  string      `json:"config_dir"`
+	DataFolder string      `json:"data_folder"`
+	PUID       int         `json:"puid"`
+	PGID       int         `json:"pgid"`
+	Excludes   []string    `json:"excludes,omitempty"`
+	Components []Component `json:"components"`
+	Files      []FileEntry `json:"files"`
+	Size       int64       `json:"size"`
+}
+
+// Component is one directory tree in a snapshot: the config directory or
+// a service's directory in FOLDER_FOR_DATA
+type Component struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Source   string   `json:"source"`
+	Stopped  []string `json:"stopped,omitempty"`
+	Files    int      `json:"files"`
+	Size     int64    `json:"size"`
+	Excluded int      `json:"excluded"`
+}
+
+// FileEntry is a regular file in a snapshot with its SHA-256 checksum
+type FileEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// idLayout is the time format of snapshot IDs. Milliseconds keep two
+// snapshots taken in the same second apart.
+const idLayout = "20060102-150405.000"
+
+// NewID returns the ID of a snapshot taken at t
+func NewID(project string, t time.Time) string {
+	return fmt.Sprintf("%s-%s", project, t.Format(idLayout))
+}
+
+// NewManifest starts the manifest of a snapshot taken now
+func NewManifest(project string) *Manifest {
+	now := time.Now()
+	m := &Manifest{
+		Version: ManifestVersion,
+		ID:      NewID(project, now),
+		Created: now.UTC(),
+		Project: project,
+	}
+	m.Host, _ = os.Hostname()
+	return m
+}
+
+// Component returns the component with the given name, or nil
+func (m *Manifest) Component(name string) *Component {
+	for i := range m.Components {
+		if m.Components[i].Name == name {
+			return &m.Components[i]
+		}
+	}
+	return nil
+}
+
+// ComponentNames returns the names of the snapshot's components in order
+func (m *Manifest) ComponentNames() []string {
+	names := make([]string, len(m.Components))
+	for i, c := range m.Components {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// ArchivePath returns the path of the snapshot's archive in dir
+func (m *Manifest) ArchivePath(dir string) string {
+	return filepath.Join(dir, m.ID+ArchiveExt)
+}
+
+// WriteManifest writes the manifest next to the snapshot's archive in dir
+func WriteManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, m.ID+ManifestExt), data, 0600)
+}
+
+// ReadManifest reads the manifest of snapshot id from dir
+func ReadManifest(dir, id string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, id+ManifestExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("snapshot %s not found in %s", id, dir)
+		}
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+// parseManifest decodes a manifest and checks its version
+func parseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid snapshot manifest: %w", err)
+	}
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("snapshot %s was written by a newer version of mediastack (manifest version %d)", m.ID, m.Version)
+	}
+	return &m, nil
+}
+
+// List returns the snapshots in dir, oldest first. Manifests that cannot be
+// read are skipped.
+func List(dir string) ([]*Manifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var snapshots []*Manifest
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ManifestExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		m, err := ReadManifest(dir, id)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, m)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+// Remove deletes snapshot id and its manifest from dir
+func Remove(dir, id string) error {
+	err := os.Remove(filepath.Join(dir, id+ArchiveExt))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, id+ManifestExt))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ownership maps the owners recorded in a snapshot onto the current host.
+// Files owned by the snapshot's PUID/PGID are given the current PUID/PGID;
+// other owners, like postgresql's, are restored as recorded.
+type Ownership struct {
+	Chown            bool
+	FromUID, FromGID int
+	ToUID, ToGID     int
+}
+
+// ids returns the owner to give a restored file
+func (o Ownership) ids(uid, gid int) (int, int) {
+	if uid == o.FromUID {
+		uid = o.ToUID
+	}
+	if gid == o.FromGID {
+		gid = o.ToGID
+	}
+	return uid, gid
+}
+
+// ExtractStats summarises an extraction
+type ExtractStats struct {
+	Files       int
+	Size        int64
+	ChownFailed int
+}
+
+// Extract reads the snapshot archive at archivePath, verifying it against
+// m, and writes each component named in targets into its target directory.
+// Every file of those components is checked against its manifest checksum,
+// and the manifest embedded in the archive must match m, so a nil error
+// means the extracted trees are complete and intact.
+func Extract(archivePath string, m *Manifest, targets map[string]string, own Ownership) (ExtractStats, error) {
+	for name, dest := range targets {
+		if dest == "" {
+			return ExtractStats{}, fmt.Errorf("no target directory for %s", name)
+		}
+	}
+	return extract(archivePath, m, targets, own)
+}
+
+// Verify reads the whole snapshot archive at archivePath and checks every
+// file against the checksums in m without writing anything
+func Verify(archivePath string, m *Manifest) (ExtractStats, error) {
+	targets := make(map[string]string, len(m.Components))
+	for _, c := range m.Components {
+		targets[c.Name] = ""
+	}
+	return extract(archivePath, m, targets, Ownership{})
+}
+
+// extract implements Extract and Verify. Components with an empty target
+// are checked but not written.
+func extract(archivePath string, m *Manifest, targets map[string]string, own Ownership) (ExtractStats, error) {
+	var stats ExtractStats
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return stats, fmt.Errorf("%s is not a snapshot archive: %w", archivePath, err)
+	}
+	defer gz.Close()
+
+	// Components are matched by archive path prefix
+	prefixes := make(map[string]string, len(targets))
+	for name, dest := range targets {
+		comp := m.Component(name)
+		if comp == nil {
+			return stats, fmt.Errorf("snapshot %s has no component %s", m.ID, name)
+		}
+		prefixes[comp.Path] = dest
+	}
+
+	expected := make(map[string]FileEntry)
+	for _, fe := range m.Files {
+		if _, ok := componentOf(fe.Path, prefixes); ok {
+			expected[fe.Path] = fe
+		}
+	}
+
+	type dirTimes struct {
+		path string
+		mode os.FileMode
+		time time.Time
+	}
+	var dirs []dirTimes
+
+	// Symlinks are created after every file is written, so no entry can be
+	// written through a restored link
+	type pendingLink struct {
+		root, path, target string
+		uid, gid           int
+	}
+	var links []pendingLink
+	embedded := false
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if name == manifestEntry {
+			embedded = true
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return stats, err
+			}
+			inner, err := parseManifest(data)
+			if err != nil {
+				return stats, err
+			}
+			if inner.ID != m.ID || len(inner.Files) != len(m.Files) {
+				return stats, fmt.Errorf("archive manifest does not match snapshot %s", m.ID)
+			}
+			continue
+		}
+
+		prefix, ok := componentOf(name, prefixes)
+		if !ok {
+			continue
+		}
+		if prefixes[prefix] == "" {
+			if hdr.Typeflag == tar.TypeReg {
+				fe, ok := expected[name]
+				if !ok {
+					return stats, fmt.Errorf("%s is in the archive but not in the manifest", name)
+				}
+				delete(expected, name)
+				if err := checkFile(tr, io.Discard, fe); err != nil {
+					return stats, err
+				}
+				stats.Files++
+				stats.Size += fe.Size
+			}
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+		if rel != "" && !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return stats, fmt.Errorf("unsafe path in snapshot: %s", hdr.Name)
+		}
+		dest := filepath.Join(prefixes[prefix], filepath.FromSlash(rel))
+		mode := os.FileMode(hdr.Mode).Perm() | tarModeBits(hdr.Mode)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0700); err != nil {
+				return stats, err
+			}
+			dirs = append(dirs, dirTimes{dest, mode, hdr.ModTime})
+
+		case tar.TypeSymlink:
+			link := pendingLink{root: prefixes[prefix], path: dest, target: hdr.Linkname, uid: -1, gid: -1}
+			if own.Chown {
+				link.uid, link.gid = own.ids(hdr.Uid, hdr.Gid)
+			}
+			links = append(links, link)
+			continue
+
+		case tar.TypeReg:
+			fe, ok := expected[name]
+			if !ok {
+				return stats, fmt.Errorf("%s is in the archive but not in the manifest", name)
+			}
+			delete(expected, name)
+			if err := extractFile(tr, dest, fe, mode); err != nil {
+				return stats, err
+			}
+			os.Chtimes(dest, hdr.ModTime, hdr.ModTime)
+			stats.Files++
+			stats.Size += fe.Size
+
+		default:
+			continue
+		}
+
+		if own.Chown {
+			uid, gid := own.ids(hdr.Uid, hdr.Gid)
+			if err := os.Lchown(dest, uid, gid); err != nil {
+				stats.ChownFailed++
+			}
+		}
+	}
+
+	if !embedded {
+		return stats, fmt.Errorf("snapshot archive %s is truncated: manifest missing", archivePath)
+	}
+	if len(expected) > 0 {
+		missing := make([]string, 0, len(expected))
+		for p := range expected {
+			missing = append(missing, p)
+		}
+		sort.Strings(missing)
+		return stats, fmt.Errorf("%d files in the manifest are missing from the archive, e.g. %s", len(missing), missing[0])
+	}
+
+	for _, l := range links {
+		// A link inside the tree of another restored link would be
+		// created wherever that one points
+		if err := checkSymlinkParents(l.root, l.path); err != nil {
+			return stats, err
+		}
+		if err := os.Symlink(l.target, l.path); err != nil {
+			return stats, err
+		}
+		if l.uid >= 0 {
+			if err := os.Lchown(l.path, l.uid, l.gid); err != nil {
+				stats.ChownFailed++
+			}
+		}
+	}
+
+	// Directory modes and times are applied last, since writing into a
+	// directory changes its mtime and a read-only mode would block it
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		os.Chmod(d.path, d.mode)
+		os.Chtimes(d.path, d.time, d.time)
+	}
+
+	return stats, nil
+}
+
+// checkSymlinkParents returns an error if a directory between root and
+// path is a symlink
+func checkSymlinkParents(root, path string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("unsafe path in snapshot: %s is inside symlink %s", path, dir)
+		}
+	}
+	return nil
+}
+
+// extractFile writes one regular file and checks its size and checksum
+func extractFile(r io.Reader, dest string, fe FileEntry, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := checkFile(r, out, fe); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dest, mode)
+}
+
+// checkFile copies a file's contents to w and checks its size and checksum
+func checkFile(r io.Reader, w io.Writer, fe FileEntry) error {
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, sum), r)
+	if err != nil {
+		return err
+	}
+	if n != fe.Size || hex.EncodeToString(sum.Sum(nil)) != fe.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s: the snapshot is corrupt", fe.Path)
+	}
+	return nil
+}
+
+// tarModeBits converts the setuid, setgid and sticky bits of a tar header
+// mode to their os.FileMode equivalents
+func tarModeBits(mode int64) os.FileMode {
+	var m os.FileMode
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// componentOf returns the component prefix that the archive path name
+// belongs to
+func componentOf(name string, prefixes map[string]string) (string, bool) {
+	for prefix := range prefixes {
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// ReplaceDir swaps the directory target for the restored tree at staged.
+// The current target, if any, is renamed to aside and left for the user
+// to inspect or delete.
+func ReplaceDir(target, staged, aside string) error {
+	if _, err := os.Lstat(target); err == nil {
+		if err := os.Rename(target, aside); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(staged, target); err != nil {
+		// Put the original back so the service finds its data
+		os.Rename(aside, target)
+		return err
+	}
+	return nil
+}
+
+// ReplaceFiles moves every file of the restored tree at staged over the
+// same path in target, saving the files it replaces under saveDir. It is
+// used for the config directory, which holds the stack lock and cannot be
+// swapped out as a whole.
+func ReplaceFiles(target, staged, saveDir string) ([]string, error) {
+	var replaced []string
+	err := filepath.WalkDir(staged, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staged, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+
+		if d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(dest, info.Mode().Perm())
+		}
+
+		if _, err := os.Lstat(dest); err == nil {
+			save := filepath.Join(saveDir, rel)
+			if err := os.MkdirAll(filepath.Dir(save), 0700); err != nil {
+				return err
+			}
+			if err := os.Rename(dest, save); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(p, dest); err != nil {
+			return err
+		}
+		replaced = append(replaced, path.Clean(filepath.ToSlash(rel)))
+		return nil
+	})
+	return replaced, err
+}
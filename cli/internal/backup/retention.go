@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Retention is how many daily and weekly snapshots to keep. The newest
+// snapshot of each of the last Daily days and of each of the last Weekly
+// ISO weeks that have one is kept; zero disables that rule.
+type Retention struct {
+	Daily  int
+	Weekly int
+}
+
+// Enabled reports whether any retention rule is set
+func (r Retention) Enabled() bool {
+	return r.Daily > 0 || r.Weekly > 0
+}
+
+// Expired returns the indexes of the snapshots taken at times that the
+// policy does not keep. The newest snapshot is always kept.
+func (r Retention) Expired(times []time.Time) []int {
+	if !r.Enabled() || len(times) == 0 {
+		return nil
+	}
+
+	order := make([]int, len(times))
+	for i := range order {
+		order[i] = i
+	}
+	// Of snapshots taken at the same time, the first listed is kept
+	sort.SliceStable(order, func(a, b int) bool {
+		return times[order[a]].After(times[order[b]])
+	})
+
+	keep := map[int]bool{order[0]: true}
+	r.keepNewestPer(times, order, r.Daily, keep, func(t time.Time) string {
+		return t.Local().Format("2006-01-02")
+	})
+	r.keepNewestPer(times, order, r.Weekly, keep, func(t time.Time) string {
+		year, week := t.Local().ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+
+	var expired []int
+	for _, i := range order {
+		if !keep[i] {
+			expired = append(expired, i)
+		}
+	}
+	sort.Ints(expired)
+	return expired
+}
+
+// keepNewestPer marks the newest snapshot in each of the n most recent
+// periods. order lists the snapshots newest first.
+func (r Retention) keepNewestPer(times []time.Time, order []int, n int, keep map[int]bool, period func(time.Time) string) {
+	seen := make(map[string]bool)
+	for _, i := range order {
+		if len(seen) == n {
+			return
+		}
+		p := period(times[i])
+		if !seen[p] {
+			seen[p] = true
+			keep[i] = true
+		}
+	}
+}
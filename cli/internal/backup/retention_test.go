@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// at returns a local time in October 2026. October 12 is a Monday, so ISO
+// weeks run Monday 5, 12 and 19 to the following Sunday.
+func at(day, hour, minute int) time.Time {
+	return time.Date(2026, time.October, day, hour, minute, 0, 0, time.Local)
+}
+
+func TestRetentionExpired(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Retention
+		times  []time.Time
+		want   []int
+	}{
+		{
+			name:   "no rules",
+			policy: Retention{},
+			times:  []time.Time{at(18, 12, 0), at(17, 12, 0)},
+		},
+		{
+			name:   "no snapshots",
+			policy: Retention{Daily: 3},
+		},
+		{
+			name:   "daily keeps the newest of each day",
+			policy: Retention{Daily: 2},
+			times:  []time.Time{at(16, 12, 0), at(17, 12, 0), at(18, 8, 0), at(18, 12, 0)},
+			want:   []int{0, 2},
+		},
+		{
+			name:   "daily boundary at midnight",
+			policy: Retention{Daily: 2},
+			times:  []time.Time{at(16, 23, 59), at(17, 0, 1), at(17, 23, 59)},
+			want:   []int{1},
+		},
+		{
+			name:   "same-day duplicates",
+			policy: Retention{Daily: 1},
+			times:  []time.Time{at(18, 12, 0), at(18, 12, 0), at(18, 12, 0)},
+			want:   []int{1, 2},
+		},
+		{
+			name:   "weekly keeps the newest of each ISO week",
+			policy: Retention{Weekly: 2},
+			times:  []time.Time{at(4, 12, 0), at(5, 12, 0), at(11, 12, 0), at(14, 12, 0), at(18, 12, 0)},
+			want:   []int{0, 1, 3},
+		},
+		{
+			name:   "weekly boundary between Sunday and Monday",
+			policy: Retention{Weekly: 2},
+			times:  []time.Time{at(11, 10, 0), at(11, 23, 0), at(12, 1, 0)},
+			want:   []int{0},
+		},
+		{
+			name:   "daily and weekly combined",
+			policy: Retention{Daily: 2, Weekly: 2},
+			times:  []time.Time{at(5, 12, 0), at(11, 12, 0), at(16, 12, 0), at(17, 12, 0), at(18, 12, 0)},
+			want:   []int{0, 2},
+		},
+		{
+			name:   "newest is always kept",
+			policy: Retention{Weekly: 1},
+			times:  []time.Time{at(18, 12, 0), at(4, 12, 0)},
+			want:   []int{1},
+		},
+		{
+			name:   "indexes refer to the unsorted input",
+			policy: Retention{Daily: 1},
+			times:  []time.Time{at(17, 12, 0), at(18, 12, 0), at(16, 12, 0)},
+			want:   []int{0, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Expired(tt.times)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Writer writes a snapshot archive. The archive is written to a temporary
+// file and only renamed into place, with its manifest alongside, by Close.
+type Writer struct {
+	dir      string
+	manifest *Manifest
+	file     *os.File
+	gz       *gzip.Writer
+	tw       *tar.Writer
+}
+
+// Create starts writing the snapshot described by m into dir
+func Create(dir string, m *Manifest) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &Writer{
+		dir:      dir,
+		manifest: m,
+		file:     f,
+		gz:       gz,
+		tw:       tar.NewWriter(gz),
+	}, nil
+}
+
+// AddTree archives the directory source under the archive path prefix,
+// skipping paths matched by excludes, and records it as a component
+func (w *Writer) AddTree(name, prefix, source string, excludes *Excludes) (Component, error) {
+	comp := Component{Name: name, Path: prefix, Source: source}
+
+	err := walkTree(prefix, source, excludes, &comp, func(archivePath, p string, info fs.FileInfo) error {
+		return w.addFile(&comp, archivePath, p, info)
+	})
+	if err != nil {
+		return comp, err
+	}
+
+	w.manifest.Components = append(w.manifest.Components, comp)
+	return comp, nil
+}
+
+// addFile writes one directory, symlink or regular file to the archive
+func (w *Writer) addFile(comp *Component, archivePath, p string, info fs.FileInfo) error {
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = archivePath
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	if !info.Mode().IsRegular() {
+		return w.tw.WriteHeader(hdr)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return permissionHint(err)
+	}
+	defer f.Close()
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	sum := sha256.New()
+	n, err := io.CopyN(io.MultiWriter(w.tw, sum), f, hdr.Size)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s shrank from %d to %d bytes while being archived; use --consistent to stop its service first", p, hdr.Size, n)
+		}
+		return err
+	}
+
+	w.manifest.Files = append(w.manifest.Files, FileEntry{
+		Path:   archivePath,
+		Size:   hdr.Size,
+		SHA256: hex.EncodeToString(sum.Sum(nil)),
+	})
+	w.manifest.Size += hdr.Size
+	comp.Files++
+	comp.Size += hdr.Size
+	return nil
+}
+
+// Close appends the manifest, moves the archive into place and writes the
+// manifest next to it
+func (w *Writer) Close() error {
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    manifestEntry,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		w.Abort()
+		return err
+	}
+	if _, err := io.Copy(w.tw, bytes.NewReader(data)); err != nil {
+		w.Abort()
+		return err
+	}
+
+	for _, c := range []io.Closer{w.tw, w.gz, w.file} {
+		if err := c.Close(); err != nil {
+			os.Remove(w.file.Name())
+			return err
+		}
+	}
+
+	// Link rather than rename, so an existing snapshot is never replaced
+	err = os.Link(w.file.Name(), w.manifest.ArchivePath(w.dir))
+	os.Remove(w.file.Name())
+	if os.IsExist(err) {
+		return fmt.Errorf("snapshot %s already exists in %s", w.manifest.ID, w.dir)
+	}
+	if err != nil {
+		return err
+	}
+	return WriteManifest(w.dir, w.manifest)
+}
+
+// Abort discards the partly written archive
+func (w *Writer) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// Measure walks source as AddTree would, counting the files and bytes that
+// would be archived
+func Measure(name, prefix, source string, excludes *Excludes) (Component, error) {
+	comp := Component{Name: name, Path: prefix, Source: source}
+	err := walkTree(prefix, source, excludes, &comp, func(archivePath, p string, info fs.FileInfo) error {
+		if info.Mode().IsRegular() {
+			comp.Files++
+			comp.Size += info.Size()
+		}
+		return nil
+	})
+	return comp, err
+}
+
+// walkTree calls fn for every directory, symlink and regular file under
+// source that is not excluded, with its archive path. Files that disappear
+// during the walk are skipped; sockets, devices and pipes are ignored.
+func walkTree(prefix, source string, excludes *Excludes, comp *Component, fn func(archivePath, p string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p != source {
+				return nil
+			}
+			return permissionHint(err)
+		}
+
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		archivePath := prefix
+		if rel != "." {
+			archivePath = path.Join(prefix, filepath.ToSlash(rel))
+			if excludes.Match(archivePath) {
+				comp.Excluded++
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		return fn(archivePath, p, info)
+	})
+}
+
+// permissionHint explains how to get past permission errors, which are
+// expected for services like postgresql that run as their own user
+func permissionHint(err error) error {
+	if os.IsPermission(err) {
+		return fmt.Errorf("%w (run as root to back up files owned by other users)", err)
+	}
+	return err
+}
@@ -0,0 +1,341 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+// Target is a place snapshots are copied to: a local directory, an SSH
+// host or an S3 bucket. Objects are flat files named after the snapshot.
+type Target interface {
+	// String describes the target for messages, without credentials
+	String() string
+	// Upload copies the local file at path to the target as name
+	Upload(ctx context.Context, path, name string) error
+	// Download copies name from the target to the local file at path
+	Download(ctx context.Context, name, path string) error
+	// List returns the names of the objects at the target
+	List(ctx context.Context) ([]string, error)
+	// Delete removes name from the target
+	Delete(ctx context.Context, name string) error
+}
+
+// ParseTarget parses a target specification:
+//
+//	/mnt/usb/backups or file:///mnt/usb/backups
+//	sftp://user@host[:port]/path
+//	rsync+ssh://user@host[:port]/path
+//	s3://bucket/prefix[?endpoint=host:port&insecure=true&region=name]
+func ParseTarget(spec string) (Target, error) {
+	if !strings.Contains(spec, "://") {
+		return &localTarget{dir: spec}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup target %q: %w", spec, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return &localTarget{dir: u.Path}, nil
+	case "sftp", "rsync+ssh":
+		return newSSHTarget(u)
+	case "s3":
+		return newS3Target(u)
+	default:
+		return nil, fmt.Errorf("unsupported backup target %q (use a path, sftp://, rsync+ssh:// or s3://)", spec)
+	}
+}
+
+// IDTime returns the time encoded in a snapshot ID. IDs of snapshots taken
+// by earlier versions have second resolution.
+func IDTime(id string) (time.Time, bool) {
+	for _, layout := range []string{idLayout, "20060102-150405"} {
+		if len(id) < len(layout) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, id[len(id)-len(layout):], time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// RemoteSnapshot is a snapshot stored at a target
+type RemoteSnapshot struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`
+	Encrypted bool      `json:"encrypted"`
+	Complete  bool      `json:"complete"`
+	Objects   []string  `json:"objects"`
+}
+
+// archiveName and manifestName are the object names of a snapshot's files
+func (s RemoteSnapshot) archiveName() string  { return s.objectName(ArchiveExt) }
+func (s RemoteSnapshot) manifestName() string { return s.objectName(ManifestExt) }
+
+func (s RemoteSnapshot) objectName(ext string) string {
+	if s.Encrypted {
+		return s.ID + ext + EncryptedExt
+	}
+	return s.ID + ext
+}
+
+// ListRemote returns the snapshots at t, oldest first. A snapshot is
+// complete once its manifest, which is uploaded last, is present.
+func ListRemote(ctx context.Context, t Target) ([]RemoteSnapshot, error) {
+	names, err := t.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*RemoteSnapshot)
+	for _, name := range names {
+		base, encrypted := strings.CutSuffix(name, EncryptedExt)
+		id, isManifest := strings.CutSuffix(base, ManifestExt)
+		if !isManifest {
+			var ok bool
+			if id, ok = strings.CutSuffix(base, ArchiveExt); !ok {
+				continue
+			}
+		}
+
+		s := byID[id]
+		if s == nil {
+			created, ok := IDTime(id)
+			if !ok {
+				continue
+			}
+			s = &RemoteSnapshot{ID: id, Created: created}
+			byID[id] = s
+		}
+		s.Objects = append(s.Objects, name)
+		s.Encrypted = s.Encrypted || encrypted
+		s.Complete = s.Complete || isManifest
+	}
+
+	snapshots := make([]RemoteSnapshot, 0, len(byID))
+	for _, s := range byID {
+		snapshots = append(snapshots, *s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+// ApplyRemoteRetention deletes the snapshots at t that the policy does not
+// keep and returns their IDs. Incomplete uploads are left alone.
+func ApplyRemoteRetention(ctx context.Context, t Target, r Retention) ([]string, error) {
+	snapshots, err := ListRemote(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	var complete []RemoteSnapshot
+	var times []time.Time
+	for _, s := range snapshots {
+		if s.Complete {
+			complete = append(complete, s)
+			times = append(times, s.Created)
+		}
+	}
+
+	var removed []string
+	for _, i := range r.Expired(times) {
+		s := complete[i]
+		// The manifest goes first so a partly deleted snapshot is no
+		// longer listed as complete
+		objects := append([]string(nil), s.Objects...)
+		sort.Slice(objects, func(a, b int) bool {
+			return strings.Contains(objects[a], ManifestExt) && !strings.Contains(objects[b], ManifestExt)
+		})
+		for _, name := range objects {
+			if err := t.Delete(ctx, name); err != nil {
+				return removed, fmt.Errorf("failed to delete %s: %w", name, err)
+			}
+		}
+		removed = append(removed, s.ID)
+	}
+	return removed, nil
+}
+
+// Upload is a snapshot prepared for copying to targets, encrypted once if
+// there are recipients
+type Upload struct {
+	tmp   string
+	files [][2]string // local path, object name
+}
+
+// PrepareUpload readies snapshot m from dir for upload. With recipients the
+// archive and manifest are encrypted into a temporary directory in dir.
+func PrepareUpload(dir string, m *Manifest, recipients []age.Recipient) (*Upload, error) {
+	archive := m.ArchivePath(dir)
+	manifest := filepath.Join(dir, m.ID+ManifestExt)
+
+	if len(recipients) == 0 {
+		return &Upload{files: [][2]string{
+			{archive, m.ID + ArchiveExt},
+			{manifest, m.ID + ManifestExt},
+		}}, nil
+	}
+
+	tmp, err := os.MkdirTemp(dir, ".upload-")
+	if err != nil {
+		return nil, err
+	}
+	u := &Upload{tmp: tmp}
+	for _, src := range []string{archive, manifest} {
+		name := filepath.Base(src) + EncryptedExt
+		dst := filepath.Join(tmp, name)
+		if err := EncryptFile(src, dst, recipients); err != nil {
+			u.Close()
+			return nil, fmt.Errorf("failed to encrypt %s: %w", src, err)
+		}
+		u.files = append(u.files, [2]string{dst, name})
+	}
+	return u, nil
+}
+
+// Push copies the snapshot to t, manifest last
+func (u *Upload) Push(ctx context.Context, t Target) error {
+	for _, f := range u.files {
+		if err := t.Upload(ctx, f[0], f[1]); err != nil {
+			return fmt.Errorf("failed to upload %s to %s: %w", f[1], t, err)
+		}
+	}
+	return nil
+}
+
+// Close removes the encrypted copies
+func (u *Upload) Close() {
+	if u.tmp != "" {
+		os.RemoveAll(u.tmp)
+	}
+}
+
+// Fetch downloads snapshot s from t into dir, decrypting it with
+// identities if it is encrypted, and returns its manifest
+func Fetch(ctx context.Context, t Target, s RemoteSnapshot, dir string, identities []age.Identity) (*Manifest, error) {
+	if !s.Complete {
+		return nil, fmt.Errorf("snapshot %s at %s is incomplete (no manifest)", s.ID, t)
+	}
+	if s.Encrypted && len(identities) == 0 {
+		return nil, fmt.Errorf("snapshot %s is encrypted; an age identity is needed to read it", s.ID)
+	}
+
+	for _, f := range [][2]string{
+		{s.manifestName(), s.ID + ManifestExt},
+		{s.archiveName(), s.ID + ArchiveExt},
+	} {
+		dst := filepath.Join(dir, f[1])
+		if !s.Encrypted {
+			if err := t.Download(ctx, f[0], dst); err != nil {
+				return nil, fmt.Errorf("failed to download %s: %w", f[0], err)
+			}
+			continue
+		}
+
+		enc := dst + EncryptedExt
+		if err := t.Download(ctx, f[0], enc); err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", f[0], err)
+		}
+		err := DecryptFile(enc, dst, identities)
+		os.Remove(enc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ReadManifest(dir, s.ID)
+}
+
+// localTarget stores snapshots in a directory, e.g. on a USB disk or a
+// network mount
+type localTarget struct {
+	dir string
+}
+
+func (t *localTarget) String() string { return t.dir }
+
+func (t *localTarget) Upload(ctx context.Context, path, name string) error {
+	if err := os.MkdirAll(t.dir, 0750); err != nil {
+		return err
+	}
+	return copyFile(ctx, path, filepath.Join(t.dir, name))
+}
+
+func (t *localTarget) Download(ctx context.Context, name, path string) error {
+	return copyFile(ctx, filepath.Join(t.dir, name), path)
+}
+
+func (t *localTarget) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (t *localTarget) Delete(ctx context.Context, name string) error {
+	err := os.Remove(filepath.Join(t.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// copyFile copies src to dst through a temporary file in dst's directory
+func copyFile(ctx context.Context, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if _, err := out.ReadFrom(ctxReader{ctx, in}); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// ctxReader stops a copy when its context is cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   *os.File
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
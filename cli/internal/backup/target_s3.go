@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Target stores snapshots in an S3-compatible bucket. Credentials come
+// from AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY, MINIO_ROOT_USER /
+// MINIO_ROOT_PASSWORD or ~/.aws/credentials; the endpoint defaults to
+// S3_ENDPOINT or AWS.
+type s3Target struct {
+	client   *minio.Client
+	endpoint string
+	bucket   string
+	prefix   string
+}
+
+func newS3Target(u *url.URL) (*s3Target, error) {
+	bucket := u.Host
+	if bucket == "" {
+		return nil, fmt.Errorf("backup target %s has no bucket", u.Redacted())
+	}
+
+	q := u.Query()
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	insecure, _ := strconv.ParseBool(q.Get("insecure"))
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+	})
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !insecure,
+		Region: q.Get("region"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 target %s: %w", u.Redacted(), err)
+	}
+
+	return &s3Target{
+		client:   client,
+		endpoint: endpoint,
+		bucket:   bucket,
+		prefix:   strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+func (t *s3Target) String() string {
+	return fmt.Sprintf("s3://%s/%s (%s)", t.bucket, t.prefix, t.endpoint)
+}
+
+// key returns the object key of name
+func (t *s3Target) key(name string) string {
+	return path.Join(t.prefix, name)
+}
+
+func (t *s3Target) Upload(ctx context.Context, local, name string) error {
+	_, err := t.client.FPutObject(ctx, t.bucket, t.key(name), local, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (t *s3Target) Download(ctx context.Context, name, local string) error {
+	return t.client.FGetObject(ctx, t.bucket, t.key(name), local, minio.GetObjectOptions{})
+}
+
+func (t *s3Target) List(ctx context.Context) ([]string, error) {
+	prefix := ""
+	if t.prefix != "" {
+		prefix = t.prefix + "/"
+	}
+
+	var names []string
+	for obj := range t.client.ListObjects(ctx, t.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if name := strings.TrimPrefix(obj.Key, prefix); name != "" && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (t *s3Target) Delete(ctx context.Context, name string) error {
+	return t.client.RemoveObject(ctx, t.bucket, t.key(name), minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// sshTarget stores snapshots on an SSH host using the system ssh client,
+// so keys, agents and ~/.ssh/config work as they do for the user. It
+// transfers files with sftp, or with rsync when the scheme is rsync+ssh.
+type sshTarget struct {
+	scheme string
+	host   string // [user@]host
+	port   string
+	dir    string
+}
+
+func newSSHTarget(u *url.URL) (*sshTarget, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("backup target %s has no host", u.Redacted())
+	}
+	host := u.Hostname()
+	if u.User != nil && u.User.Username() != "" {
+		host = u.User.Username() + "@" + host
+	}
+
+	// A path starting with /~/ is relative to the remote home directory
+	dir := u.Path
+	if rest, ok := strings.CutPrefix(dir, "/~/"); ok {
+		dir = rest
+	}
+	if dir == "" || dir == "/" || dir == "/~" {
+		dir = "."
+	}
+
+	return &sshTarget{scheme: u.Scheme, host: host, port: u.Port(), dir: dir}, nil
+}
+
+func (t *sshTarget) String() string {
+	host := t.host
+	if t.port != "" {
+		host += ":" + t.port
+	}
+	return fmt.Sprintf("%s://%s/%s", t.scheme, host, strings.TrimPrefix(t.dir, "/"))
+}
+
+func (t *sshTarget) rsync() bool { return t.scheme == "rsync+ssh" }
+
+// sshOptions are passed to every ssh, sftp and rsync invocation
+func (t *sshTarget) sshOptions(portFlag string) []string {
+	opts := []string{"-o", "BatchMode=yes"}
+	if t.port != "" {
+		opts = append(opts, portFlag, t.port)
+	}
+	return opts
+}
+
+// ssh runs a shell command on the host
+func (t *sshTarget) ssh(ctx context.Context, command string) (string, error) {
+	args := append(t.sshOptions("-p"), t.host, command)
+	return runTool(ctx, "ssh", args...)
+}
+
+// sftp runs a batch of sftp commands; a leading - lets a command fail
+func (t *sshTarget) sftp(ctx context.Context, commands ...string) (string, error) {
+	args := append(t.sshOptions("-P"), "-q", "-b", "-", t.host)
+	cmd := exec.CommandContext(ctx, "sftp", args...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("sftp: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// remote returns the path of name on the host
+func (t *sshTarget) remote(name string) string {
+	return path.Join(t.dir, name)
+}
+
+func (t *sshTarget) Upload(ctx context.Context, local, name string) error {
+	partial := t.remote(name + ".partial")
+
+	if t.rsync() {
+		if _, err := t.ssh(ctx, "mkdir -p -- "+shellQuote(t.dir)); err != nil {
+			return err
+		}
+		rsh := strings.Join(append([]string{"ssh"}, t.sshOptions("-p")...), " ")
+		if _, err := runTool(ctx, "rsync", "--partial", "--protect-args", "-e", rsh, local, t.host+":"+partial); err != nil {
+			return err
+		}
+		_, err := t.ssh(ctx, fmt.Sprintf("mv -f -- %s %s", shellQuote(partial), shellQuote(t.remote(name))))
+		return err
+	}
+
+	_, err := t.sftp(ctx,
+		"-mkdir "+sftpQuote(t.dir),
+		"put "+sftpQuote(local)+" "+sftpQuote(partial),
+		"-rm "+sftpQuote(t.remote(name)),
+		"rename "+sftpQuote(partial)+" "+sftpQuote(t.remote(name)),
+	)
+	return err
+}
+
+func (t *sshTarget) Download(ctx context.Context, name, local string) error {
+	if t.rsync() {
+		rsh := strings.Join(append([]string{"ssh"}, t.sshOptions("-p")...), " ")
+		_, err := runTool(ctx, "rsync", "--protect-args", "-e", rsh, t.host+":"+t.remote(name), local)
+		return err
+	}
+	_, err := t.sftp(ctx, "get "+sftpQuote(t.remote(name))+" "+sftpQuote(local))
+	return err
+}
+
+func (t *sshTarget) List(ctx context.Context) ([]string, error) {
+	var out string
+	var err error
+	if t.rsync() {
+		out, err = t.ssh(ctx, fmt.Sprintf("ls -1 -- %s 2>/dev/null || true", shellQuote(t.dir)))
+	} else {
+		out, err = t.sftp(ctx, "-ls -1 "+sftpQuote(t.dir))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "sftp>") {
+			continue
+		}
+		names = append(names, path.Base(line))
+	}
+	return names, nil
+}
+
+func (t *sshTarget) Delete(ctx context.Context, name string) error {
+	if t.rsync() {
+		_, err := t.ssh(ctx, "rm -f -- "+shellQuote(t.remote(name)))
+		return err
+	}
+	_, err := t.sftp(ctx, "-rm "+sftpQuote(t.remote(name)))
+	return err
+}
+
+// runTool runs an external command and returns its output
+func runTool(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sftpQuote quotes s for an sftp batch command
+func sftpQuote(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
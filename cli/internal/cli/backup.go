@@ -0,0 +1,948 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/backup"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Snapshot service data and configuration",
+	Long: `Create and manage snapshots of the stack's service data in
+FOLDER_FOR_DATA and of the config directory (.env, YAML files, hooks).
+
+Snapshots are stored in FOLDER_FOR_BACKUPS from .env, or in a backups
+directory next to .env, unless --dir is given. They can also be copied,
+optionally age-encrypted, to backup targets: another directory, an SSH
+host (sftp:// or rsync+ssh://) or S3-compatible storage (s3://).`,
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create [service...]",
+	Short: "Snapshot service data directories and the config directory",
+	Long: `Archive the data directories of the given services (all of them
+by default) and the config directory into a compressed snapshot with a
+manifest listing every file and its SHA-256 checksum.
+
+With --consistent, the services that bind mount each directory are
+stopped while it is archived, together with the services that depend on
+them (e.g. Authentik and Guacamole for postgresql), and started again
+straight afterwards in dependency order. Without it, services keep
+running and databases may be captured mid-write.
+
+Caches, transcodes and logs are excluded by default; see --exclude.
+Rules without a slash match a file or directory name anywhere, others
+match the archive path, e.g. data/sonarr/MediaCover or data/**/Backups.
+
+With --keep-daily or --keep-weekly, older snapshots outside the retention
+policy are deleted after the new one is written.
+
+The snapshot is then uploaded to each --target (default BACKUP_TARGETS
+from .env, comma separated), encrypted to the --recipient age or SSH
+public keys (default BACKUP_AGE_RECIPIENTS) if any. The retention policy
+is applied at each target too.
+
+  /mnt/usb/backups
+  sftp://user@nas/backups         (relative to home: sftp://nas/~/backups)
+  rsync+ssh://user@nas:2222/backups
+  s3://bucket/prefix?endpoint=localhost:9000&insecure=true`,
+	RunE: runBackupCreate,
+}
+
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify <snapshot|latest>",
+	Short: "Check a snapshot's archive against its manifest",
+	Long: `Read a snapshot's whole archive and check every file against the
+size and SHA-256 checksum in its manifest.
+
+With --target the snapshot is first downloaded from that target and
+decrypted with --identity (default BACKUP_AGE_IDENTITY) if it is
+encrypted. Use --keep to store the verified copy in the snapshot
+directory so it can be restored with 'backup restore'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBackupVerify,
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List snapshots",
+	RunE:  runBackupList,
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <snapshot> [service...]",
+	Short: "Restore services' data directories from a snapshot",
+	Long: `Restore the data directories of the given services (all of them
+by default) from a snapshot. Name "config" to restore the config directory
+as well. The snapshot may be given by ID or as a path to its archive.
+
+The archive is first extracted next to each directory while every file
+is checked against the manifest's checksums. Only when that succeeds are
+the services using the directories stopped, the current directories
+moved aside to <dir>.pre-restore-<timestamp>, the restored trees moved
+into place, and the services started again. Files keep the ownership
+recorded in the snapshot, with the snapshot's PUID/PGID mapped to the
+current ones. Config files that are replaced are saved in
+config-backups/.
+
+With --to, the selected components are extracted into a directory for
+inspection instead, leaving the stack untouched.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runBackupRestore,
+}
+
+func init() {
+	backupCmd.AddCommand(backupCreateCmd)
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	backupCmd.AddCommand(backupVerifyCmd)
+
+	backupCmd.PersistentFlags().String("dir", "", "Snapshot directory (default FOLDER_FOR_BACKUPS or <config>/backups)")
+
+	backupCreateCmd.Flags().Bool("consistent", false, "Stop each service while its directory is archived")
+	backupCreateCmd.Flags().StringSlice("exclude", nil, "Additional exclude rule (repeatable)")
+	backupCreateCmd.Flags().Bool("no-default-excludes", false, "Do not exclude caches, transcodes and logs")
+	backupCreateCmd.Flags().Bool("no-config", false, "Do not include the config directory")
+	backupCreateCmd.Flags().Int("keep-daily", 0, "Keep the newest snapshot of each of the last N days")
+	backupCreateCmd.Flags().Int("keep-weekly", 0, "Keep the newest snapshot of each of the last N weeks")
+	backupCreateCmd.Flags().StringSlice("target", nil, "Upload to this target (repeatable, default BACKUP_TARGETS)")
+	backupCreateCmd.Flags().StringSlice("recipient", nil, "Encrypt uploads to this age/SSH public key or recipients file (repeatable)")
+	backupCreateCmd.Flags().Bool("local-only", false, "Do not upload to any target")
+
+	backupListCmd.Flags().Bool("json", false, "Output as JSON")
+	backupListCmd.Flags().String("target", "", "List the snapshots at this target instead")
+
+	backupVerifyCmd.Flags().String("target", "", "Download the snapshot from this target")
+	backupVerifyCmd.Flags().StringSlice("identity", nil, "age identity or SSH private key file to decrypt with (repeatable)")
+	backupVerifyCmd.Flags().Bool("keep", false, "Keep the downloaded snapshot in the snapshot directory")
+
+	backupRestoreCmd.Flags().String("to", "", "Extract into this directory instead of restoring in place")
+	backupRestoreCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+}
+
+// backupSnapshotDir returns the directory snapshots are stored in
+func backupSnapshotDir(cmd *cobra.Command) string {
+	if dir, _ := cmd.Flags().GetString("dir"); dir != "" {
+		return dir
+	}
+	if dir := cfg.Env["FOLDER_FOR_BACKUPS"]; dir != "" {
+		return dir
+	}
+	return filepath.Join(cfg.ConfigDir, "backups")
+}
+
+// backupSource is a directory tree to archive
+type backupSource struct {
+	name   string
+	prefix string
+	path   string
+}
+
+func runBackupCreate(cmd *cobra.Command, args []string) error {
+	consistent, _ := cmd.Flags().GetBool("consistent")
+	extra, _ := cmd.Flags().GetStringSlice("exclude")
+	noDefaults, _ := cmd.Flags().GetBool("no-default-excludes")
+	noConfig, _ := cmd.Flags().GetBool("no-config")
+	keepDaily, _ := cmd.Flags().GetInt("keep-daily")
+	keepWeekly, _ := cmd.Flags().GetInt("keep-weekly")
+	localOnly, _ := cmd.Flags().GetBool("local-only")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Hour)
+	defer cancel()
+
+	dir := backupSnapshotDir(cmd)
+	excludes := backupExcludes(dir, extra, noDefaults)
+
+	sources, err := backupSources(ctx, args, excludes, noConfig)
+	if err != nil {
+		return err
+	}
+
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+
+	var targets []backup.Target
+	var recipients []age.Recipient
+	if !localOnly {
+		targets, err = backupTargets(cmd)
+		if err != nil {
+			return err
+		}
+		specs, _ := cmd.Flags().GetStringSlice("recipient")
+		if len(specs) == 0 {
+			specs = splitList(cfg.Env["BACKUP_AGE_RECIPIENTS"])
+		}
+		recipients, err = backup.ParseRecipients(specs)
+		if err != nil {
+			return err
+		}
+	}
+
+	var project *docker.Project
+	if consistent {
+		project, err = compose.Model(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load compose model: %w", err)
+		}
+	}
+
+	if dryRun {
+		for _, t := range targets {
+			encrypted := ""
+			if len(recipients) > 0 {
+				encrypted = fmt.Sprintf(", encrypted to %d recipient(s)", len(recipients))
+			}
+			color.Cyan("[dry-run] Would upload to %s%s", t, encrypted)
+		}
+		return showBackupPlan(ctx, compose, project, sources, excludes, dir)
+	}
+
+	release, err := acquireStackLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	manifest := backup.NewManifest(cfg.ProjectName)
+	manifest.Variant = cfg.Variant
+	manifest.Consistent = consistent
+	manifest.ConfigDir = cfg.ConfigDir
+	manifest.DataFolder = cfg.DataFolder
+	manifest.PUID = cfg.PUID
+	manifest.PGID = cfg.PGID
+	manifest.Excludes = excludes.Rules()
+
+	w, err := backup.Create(dir, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	color.Cyan("Creating snapshot %s in %s...", manifest.ID, dir)
+	for _, src := range sources {
+		if err := archiveBackupSource(ctx, w, compose, project, manifest, src, excludes); err != nil {
+			w.Abort()
+			return fmt.Errorf("failed to archive %s: %w", src.name, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	size := ""
+	if info, err := os.Stat(manifest.ArchivePath(dir)); err == nil {
+		size = ", " + formatBytes(uint64(info.Size())) + " compressed"
+	}
+	color.Green("Snapshot %s: %d files, %s%s", manifest.ID, len(manifest.Files), formatBytes(uint64(manifest.Size)), size)
+
+	retention := backup.Retention{Daily: keepDaily, Weekly: keepWeekly}
+	if retention.Enabled() {
+		if err := applyBackupRetention(dir, retention); err != nil {
+			return err
+		}
+	}
+
+	if len(targets) > 0 {
+		return uploadBackup(ctx, dir, manifest, targets, recipients, retention)
+	}
+	return nil
+}
+
+// backupTargets parses the --target flags, or BACKUP_TARGETS from .env
+func backupTargets(cmd *cobra.Command) ([]backup.Target, error) {
+	specs, _ := cmd.Flags().GetStringSlice("target")
+	if len(specs) == 0 {
+		specs = splitList(cfg.Env["BACKUP_TARGETS"])
+	}
+
+	var targets []backup.Target
+	for _, spec := range specs {
+		t, err := backup.ParseTarget(spec)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// splitList splits a comma-separated .env value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// uploadBackup copies a snapshot to each target and applies the retention
+// policy there. A failing target does not stop the others.
+func uploadBackup(ctx context.Context, dir string, manifest *backup.Manifest, targets []backup.Target, recipients []age.Recipient, retention backup.Retention) error {
+	if len(recipients) > 0 {
+		color.Cyan("Encrypting snapshot to %d recipient(s)...", len(recipients))
+	}
+	upload, err := backup.PrepareUpload(dir, manifest, recipients)
+	if err != nil {
+		return err
+	}
+	defer upload.Close()
+
+	failed := 0
+	for _, t := range targets {
+		color.Cyan("Uploading to %s...", t)
+		if err := upload.Push(ctx, t); err != nil {
+			color.Red("  %v", err)
+			failed++
+			continue
+		}
+
+		if retention.Enabled() {
+			removed, err := backup.ApplyRemoteRetention(ctx, t, retention)
+			for _, id := range removed {
+				fmt.Printf("  Removed %s (retention)\n", id)
+			}
+			if err != nil {
+				color.Red("  Retention failed: %v", err)
+				failed++
+				continue
+			}
+		}
+		color.Green("  Uploaded %s", manifest.ID)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets failed; the snapshot is saved locally in %s", failed, len(targets), dir)
+	}
+	return nil
+}
+
+// backupExcludes builds the exclude rules, always leaving out the stack
+// lock, the snapshot directory itself, and the data and media folders when
+// they live inside the config directory
+func backupExcludes(dir string, extra []string, noDefaults bool) *backup.Excludes {
+	var rules []string
+	if !noDefaults {
+		rules = append(rules, backup.DefaultExcludes...)
+	}
+	rules = append(rules, extra...)
+	rules = append(rules, backup.ConfigPrefix+"/"+stack.LockFile)
+
+	nested := []struct{ prefix, root, path string }{
+		{backup.ConfigPrefix, cfg.ConfigDir, dir},
+		{backup.ConfigPrefix, cfg.ConfigDir, cfg.DataFolder},
+		{backup.ConfigPrefix, cfg.ConfigDir, cfg.MediaFolder},
+		{backup.DataPrefix, cfg.DataFolder, dir},
+	}
+	for _, n := range nested {
+		if rel, err := filepath.Rel(n.root, n.path); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			rules = append(rules, n.prefix+"/"+filepath.ToSlash(rel))
+		}
+	}
+	return backup.NewExcludes(rules)
+}
+
+// backupSources resolves the directories to archive: the config directory
+// and the named service directories in FOLDER_FOR_DATA, or every one that
+// exists and is not excluded
+func backupSources(ctx context.Context, names []string, excludes *backup.Excludes, noConfig bool) ([]backupSource, error) {
+	var sources []backupSource
+	if !noConfig {
+		sources = append(sources, backupSource{name: "config", prefix: backup.ConfigPrefix, path: cfg.ConfigDir})
+	}
+
+	explicit := len(names) > 0
+	if !explicit {
+		names = serviceDataDirectories(ctx)
+	}
+
+	for _, name := range names {
+		prefix := backup.DataPrefix + "/" + name
+		path := filepath.Join(cfg.DataFolder, name)
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			if explicit {
+				return nil, fmt.Errorf("no data directory for %s at %s", name, path)
+			}
+			continue
+		}
+		if excludes.Match(prefix) {
+			if explicit {
+				return nil, fmt.Errorf("%s is excluded by the exclude rules", prefix)
+			}
+			continue
+		}
+		sources = append(sources, backupSource{name: name, prefix: prefix, path: path})
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("nothing to back up")
+	}
+	return sources, nil
+}
+
+// servicesToQuiesce returns the running services to stop while path is
+// archived, in stop order
+func servicesToQuiesce(ctx context.Context, compose *docker.Compose, project *docker.Project, path string) []string {
+	if project == nil {
+		return nil
+	}
+	var running []string
+	for _, service := range project.StopOrder(project.ServicesMounting(path)) {
+		if ok, err := compose.IsRunning(ctx, service); err == nil && ok {
+			running = append(running, service)
+		}
+	}
+	return running
+}
+
+// archiveBackupSource archives one directory, stopping the services using
+// it first in consistent mode and starting them again afterwards
+func archiveBackupSource(ctx context.Context, w *backup.Writer, compose *docker.Compose, project *docker.Project, manifest *backup.Manifest, src backupSource, excludes *backup.Excludes) error {
+	var stopped []string
+	if src.prefix != backup.ConfigPrefix {
+		stopped = servicesToQuiesce(ctx, compose, project, src.path)
+	}
+
+	if len(stopped) > 0 {
+		color.Cyan("  Stopping %s...", strings.Join(stopped, ", "))
+		defer func() {
+			for i := len(stopped) - 1; i >= 0; i-- {
+				service := stopped[i]
+				if err := compose.StartService(ctx, service); err != nil {
+					color.Red("  Failed to start %s: %v", service, err)
+				}
+			}
+		}()
+		for _, service := range stopped {
+			if err := compose.StopService(ctx, service); err != nil {
+				return fmt.Errorf("failed to stop %s: %w", service, err)
+			}
+		}
+	}
+
+	comp, err := w.AddTree(src.name, src.prefix, src.path, excludes)
+	if err != nil {
+		return err
+	}
+	manifest.Component(src.name).Stopped = stopped
+
+	line := fmt.Sprintf("  %s: %d files, %s", src.name, comp.Files, formatBytes(uint64(comp.Size)))
+	if comp.Excluded > 0 {
+		line += fmt.Sprintf(" (%d excluded)", comp.Excluded)
+	}
+	fmt.Println(line)
+	return nil
+}
+
+// showBackupPlan prints what a snapshot would contain without writing it
+func showBackupPlan(ctx context.Context, compose *docker.Compose, project *docker.Project, sources []backupSource, excludes *backup.Excludes, dir string) error {
+	color.Cyan("[dry-run] Would create a snapshot in %s", dir)
+	fmt.Println()
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Component", "Source", "Files", "Size", "Excluded", "Stops"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	var files int
+	var size int64
+	for _, src := range sources {
+		comp, err := backup.Measure(src.name, src.prefix, src.path, excludes)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", src.name, err)
+		}
+		files += comp.Files
+		size += comp.Size
+
+		var stops []string
+		if src.prefix != backup.ConfigPrefix {
+			stops = servicesToQuiesce(ctx, compose, project, src.path)
+		}
+		table.Append([]string{
+			src.name,
+			src.path,
+			fmt.Sprintf("%d", comp.Files),
+			formatBytes(uint64(comp.Size)),
+			fmt.Sprintf("%d", comp.Excluded),
+			strings.Join(stops, ", "),
+		})
+	}
+
+	table.SetFooter([]string{"Total", "", fmt.Sprintf("%d", files), formatBytes(uint64(size)), "", ""})
+	table.Render()
+	return nil
+}
+
+// applyBackupRetention deletes the snapshots in dir that the retention
+// policy does not keep
+func applyBackupRetention(dir string, retention backup.Retention) error {
+	snapshots, err := backup.List(dir)
+	if err != nil {
+		return err
+	}
+
+	times := make([]time.Time, len(snapshots))
+	for i, s := range snapshots {
+		times[i] = s.Created
+	}
+
+	for _, i := range retention.Expired(times) {
+		if err := backup.Remove(dir, snapshots[i].ID); err != nil {
+			return fmt.Errorf("failed to remove snapshot %s: %w", snapshots[i].ID, err)
+		}
+		fmt.Printf("  Removed %s (retention)\n", snapshots[i].ID)
+	}
+	return nil
+}
+
+func runBackupList(cmd *cobra.Command, args []string) error {
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	target, _ := cmd.Flags().GetString("target")
+	dir := backupSnapshotDir(cmd)
+
+	if target != "" {
+		return listRemoteBackups(target, jsonOutput)
+	}
+
+	snapshots, err := backup.List(dir)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		// File lists are omitted; they are in each snapshot's manifest
+		for _, s := range snapshots {
+			s.Files = nil
+		}
+		data, err := json.MarshalIndent(snapshots, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Printf("No snapshots in %s\n", dir)
+		return nil
+	}
+
+	fmt.Printf("Snapshots in %s\n\n", dir)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Created", "Mode", "Components", "Files", "Size", "Archive"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		s := snapshots[i]
+		mode := "online"
+		if s.Consistent {
+			mode = "consistent"
+		}
+		archive := color.RedString("missing")
+		if info, err := os.Stat(s.ArchivePath(dir)); err == nil {
+			archive = formatBytes(uint64(info.Size()))
+		}
+		table.Append([]string{
+			s.ID,
+			s.Created.Local().Format("2006-01-02 15:04"),
+			mode,
+			truncateString(strings.Join(s.ComponentNames(), ", "), 50),
+			fmt.Sprintf("%d", len(s.Files)),
+			formatBytes(uint64(s.Size)),
+			archive,
+		})
+	}
+
+	table.Render()
+	return nil
+}
+
+// resolveSnapshot finds a snapshot by ID in the snapshot directory, or by
+// the path of its archive or manifest
+func resolveSnapshot(cmd *cobra.Command, arg string) (string, *backup.Manifest, error) {
+	dir, id := backupSnapshotDir(cmd), arg
+	for _, ext := range []string{backup.ArchiveExt, backup.ManifestExt} {
+		if trimmed, ok := strings.CutSuffix(arg, ext); ok {
+			dir, id = filepath.Dir(arg), filepath.Base(trimmed)
+		}
+	}
+
+	m, err := backup.ReadManifest(dir, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, m, nil
+}
+
+func runBackupRestore(cmd *cobra.Command, args []string) error {
+	to, _ := cmd.Flags().GetString("to")
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Hour)
+	defer cancel()
+
+	dir, manifest, err := resolveSnapshot(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	names := args[1:]
+	if len(names) == 0 {
+		for _, c := range manifest.Components {
+			if c.Path != backup.ConfigPrefix || to != "" {
+				names = append(names, c.Name)
+			}
+		}
+	}
+	for _, name := range names {
+		if manifest.Component(name) == nil {
+			return fmt.Errorf("snapshot %s has no %s (has %s)", manifest.ID, name, strings.Join(manifest.ComponentNames(), ", "))
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("nothing to restore")
+	}
+
+	own := backup.Ownership{
+		Chown:   os.Geteuid() == 0,
+		FromUID: manifest.PUID,
+		FromGID: manifest.PGID,
+		ToUID:   cfg.PUID,
+		ToGID:   cfg.PGID,
+	}
+
+	if to != "" {
+		return extractBackup(dir, manifest, names, to, own)
+	}
+
+	stamp := time.Now().Format("20060102-150405")
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+
+	// Services using any restored directory, stopped in dependency order
+	var mounted []string
+	for _, name := range names {
+		if c := manifest.Component(name); c.Path != backup.ConfigPrefix {
+			mounted = append(mounted, filepath.Join(cfg.DataFolder, name))
+		}
+	}
+	var stop []string
+	if len(mounted) > 0 {
+		project, err := compose.Model(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load compose model: %w", err)
+		}
+		var services []string
+		for _, path := range mounted {
+			services = append(services, project.ServicesMounting(path)...)
+		}
+		for _, service := range project.StopOrder(services) {
+			if ok, err := compose.IsRunning(ctx, service); err == nil && ok {
+				stop = append(stop, service)
+			}
+		}
+	}
+
+	fmt.Printf("Snapshot:  %s (%s)\n", manifest.ID, manifest.Created.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("Restore:   %s\n", strings.Join(names, ", "))
+	if len(stop) > 0 {
+		fmt.Printf("Stops:     %s\n", strings.Join(stop, ", "))
+	}
+
+	if dryRun {
+		for _, name := range names {
+			target := restoreTarget(manifest, name)
+			if name == "config" {
+				color.Cyan("[dry-run] Would restore config files into %s", target)
+				continue
+			}
+			color.Cyan("[dry-run] Would move %s to %s.pre-restore-%s and restore it", target, target, stamp)
+		}
+		return nil
+	}
+
+	if !yes {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("restore replaces the current data; use --yes to confirm")
+		}
+		if !confirm(fmt.Sprintf("Replace %s with the snapshot?", strings.Join(names, ", "))) {
+			return fmt.Errorf("restore cancelled")
+		}
+	}
+
+	release, err := acquireStackLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Extract and verify beside each target before anything is stopped
+	staged := make(map[string]string, len(names))
+	for _, name := range names {
+		target := restoreTarget(manifest, name)
+		parent := filepath.Dir(target)
+		if name == "config" {
+			parent = target
+		}
+		if err := os.MkdirAll(parent, 0775); err != nil {
+			return err
+		}
+		tmp, err := os.MkdirTemp(parent, ".restore-"+name+"-")
+		if err != nil {
+			return err
+		}
+		staged[name] = filepath.Join(tmp, name)
+		defer os.RemoveAll(tmp)
+	}
+
+	color.Cyan("Extracting and verifying %s...", manifest.ArchivePath(dir))
+	stats, err := backup.Extract(manifest.ArchivePath(dir), manifest, staged, own)
+	if err != nil {
+		return err
+	}
+
+	if len(stop) > 0 {
+		color.Cyan("Stopping %s...", strings.Join(stop, ", "))
+		defer func() {
+			color.Cyan("Starting %s...", strings.Join(stop, ", "))
+			for i := len(stop) - 1; i >= 0; i-- {
+				if err := compose.StartService(ctx, stop[i]); err != nil {
+					color.Red("Failed to start %s: %v", stop[i], err)
+				}
+			}
+		}()
+		for _, service := range stop {
+			if err := compose.StopService(ctx, service); err != nil {
+				return fmt.Errorf("failed to stop %s: %w", service, err)
+			}
+		}
+	}
+
+	for _, name := range names {
+		target := restoreTarget(manifest, name)
+		if name == "config" {
+			saveDir := filepath.Join(cfg.ConfigDir, stack.ConfigBackupDir, "restore-"+stamp)
+			replaced, err := backup.ReplaceFiles(target, staged[name], saveDir)
+			if err != nil {
+				return fmt.Errorf("failed to restore config files: %w", err)
+			}
+			fmt.Printf("  config: %d files restored, previous versions in %s\n", len(replaced), saveDir)
+			continue
+		}
+
+		aside := fmt.Sprintf("%s.pre-restore-%s", target, stamp)
+		if err := backup.ReplaceDir(target, staged[name], aside); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+		fmt.Printf("  %s: restored, previous data in %s\n", name, aside)
+	}
+
+	if stats.ChownFailed > 0 {
+		color.Yellow("Warning: Could not set ownership of %d files", stats.ChownFailed)
+	} else if !own.Chown {
+		color.Yellow("Warning: Not running as root, restored files are owned by the current user")
+	}
+	color.Green("Restored %d files (%s) from %s", stats.Files, formatBytes(uint64(stats.Size)), manifest.ID)
+	return nil
+}
+
+// restoreTarget is the directory a component is restored into: the
+// current config directory or service directory in FOLDER_FOR_DATA
+func restoreTarget(m *backup.Manifest, name string) string {
+	if m.Component(name).Path == backup.ConfigPrefix {
+		return cfg.ConfigDir
+	}
+	return filepath.Join(cfg.DataFolder, name)
+}
+
+// extractBackup extracts components into dir/<archive path> for inspection
+func extractBackup(dir string, m *backup.Manifest, names []string, to string, own backup.Ownership) error {
+	targets := make(map[string]string, len(names))
+	for _, name := range names {
+		dest := filepath.Join(to, filepath.FromSlash(m.Component(name).Path))
+		if entries, err := os.ReadDir(dest); err == nil && len(entries) > 0 {
+			return fmt.Errorf("%s already exists and is not empty", dest)
+		}
+		targets[name] = dest
+	}
+
+	if dryRun {
+		for _, name := range names {
+			color.Cyan("[dry-run] Would extract %s to %s", name, targets[name])
+		}
+		return nil
+	}
+
+	color.Cyan("Extracting and verifying %s...", m.ArchivePath(dir))
+	stats, err := backup.Extract(m.ArchivePath(dir), m, targets, own)
+	if err != nil {
+		return err
+	}
+	color.Green("Extracted %d files (%s) to %s", stats.Files, formatBytes(uint64(stats.Size)), to)
+	return nil
+}
+
+// listRemoteBackups prints the snapshots stored at a target
+func listRemoteBackups(spec string, jsonOutput bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	t, err := backup.ParseTarget(spec)
+	if err != nil {
+		return err
+	}
+	snapshots, err := backup.ListRemote(ctx, t)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", t, err)
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(snapshots, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Printf("No snapshots at %s\n", t)
+		return nil
+	}
+
+	fmt.Printf("Snapshots at %s\n\n", t)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Created", "Encrypted", "Complete"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		s := snapshots[i]
+		complete := "yes"
+		if !s.Complete {
+			complete = color.YellowString("no")
+		}
+		encrypted := "no"
+		if s.Encrypted {
+			encrypted = "yes"
+		}
+		table.Append([]string{s.ID, s.Created.Format("2006-01-02 15:04"), encrypted, complete})
+	}
+
+	table.Render()
+	return nil
+}
+
+func runBackupVerify(cmd *cobra.Command, args []string) error {
+	target, _ := cmd.Flags().GetString("target")
+	keep, _ := cmd.Flags().GetBool("keep")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Hour)
+	defer cancel()
+
+	if target == "" {
+		id := args[0]
+		if id == "latest" {
+			snapshots, err := backup.List(backupSnapshotDir(cmd))
+			if err != nil {
+				return err
+			}
+			if len(snapshots) == 0 {
+				return fmt.Errorf("no snapshots in %s", backupSnapshotDir(cmd))
+			}
+			id = snapshots[len(snapshots)-1].ID
+		}
+		dir, manifest, err := resolveSnapshot(cmd, id)
+		if err != nil {
+			return err
+		}
+		return verifyBackup(dir, manifest)
+	}
+
+	t, err := backup.ParseTarget(target)
+	if err != nil {
+		return err
+	}
+	snapshots, err := backup.ListRemote(ctx, t)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", t, err)
+	}
+
+	var snapshot *backup.RemoteSnapshot
+	for i := range snapshots {
+		if snapshots[i].ID == args[0] || (args[0] == "latest" && snapshots[i].Complete) {
+			snapshot = &snapshots[i]
+		}
+	}
+	if snapshot == nil {
+		return fmt.Errorf("snapshot %s not found at %s", args[0], t)
+	}
+
+	specs, _ := cmd.Flags().GetStringSlice("identity")
+	if len(specs) == 0 {
+		specs = splitList(cfg.Env["BACKUP_AGE_IDENTITY"])
+	}
+	identities, err := backup.ParseIdentities(specs)
+	if err != nil {
+		return err
+	}
+
+	dir := backupSnapshotDir(cmd)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(dir, ".verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	color.Cyan("Downloading %s from %s...", snapshot.ID, t)
+	manifest, err := backup.Fetch(ctx, t, *snapshot, tmp, identities)
+	if err != nil {
+		return err
+	}
+	if err := verifyBackup(tmp, manifest); err != nil {
+		return err
+	}
+
+	if keep {
+		if _, err := os.Stat(manifest.ArchivePath(dir)); err == nil {
+			return fmt.Errorf("%s already exists in %s", manifest.ID, dir)
+		}
+		if err := os.Rename(manifest.ArchivePath(tmp), manifest.ArchivePath(dir)); err != nil {
+			return err
+		}
+		if err := backup.WriteManifest(dir, manifest); err != nil {
+			return err
+		}
+		color.Green("Saved %s to %s", manifest.ID, dir)
+	}
+	return nil
+}
+
+// verifyBackup checks a snapshot archive and reports the result
+func verifyBackup(dir string, manifest *backup.Manifest) error {
+	color.Cyan("Verifying %s...", manifest.ArchivePath(dir))
+	stats, err := backup.Verify(manifest.ArchivePath(dir), manifest)
+	if err != nil {
+		return fmt.Errorf("snapshot %s failed verification: %w", manifest.ID, err)
+	}
+	color.Green("Snapshot %s is intact: %d files, %s", manifest.ID, stats.Files, formatBytes(uint64(stats.Size)))
+	return nil
+}
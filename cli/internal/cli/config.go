@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the stack's configuration files",
+}
+
+var configRenderCmd = &cobra.Command{
+	Use:   "render <file>",
+	Short: "Print a config file rendered with the .env values",
+	Long: `Print a config file with its ${VAR} references filled in from .env,
+exactly as deploy would write it to the data folder.
+
+<file> is a config file name such as traefik-dynamic.yaml, its
+destination in the data folder such as traefik/dynamic.yaml, or a path.
+Fails listing every variable that is not set in .env.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runConfigRender,
+}
+
+var configDriftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "List deployed config files that no longer match their source",
+	Long: `Compare each config file in the data folder with its source in the
+config directory, rendered with the .env values, and list the ones that
+differ or have not been deployed.
+
+A deployed file drifts when it was edited in place or when its source or
+.env changed since the last deploy. The next deploy backs drifted files up
+to config-backups/ before overwriting them, unless --keep-local is used.`,
+	RunE: runConfigDrift,
+}
+
+func init() {
+	configDriftCmd.Flags().Bool("diff", false, "Show a diff for each drifted file")
+	configDriftCmd.Flags().Bool("json", false, "Output as JSON")
+	configCmd.AddCommand(configRenderCmd)
+	configCmd.AddCommand(configDriftCmd)
+}
+
+// resolveConfigFiles maps config file names or destinations to their
+// source names
+func resolveConfigFiles(names []string) ([]string, error) {
+	sources := make([]string, 0, len(names))
+	for _, name := range names {
+		cf, ok := stack.FindConfigFile(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown config file: %s", name)
+		}
+		sources = append(sources, cf.Source)
+	}
+	return sources, nil
+}
+
+func runConfigRender(cmd *cobra.Command, args []string) error {
+	var data []byte
+	var err error
+
+	if cf, ok := stack.FindConfigFile(args[0]); ok {
+		data, err = stack.RenderConfigFile(cfg.ConfigDir, cf, cfg.Env)
+	} else {
+		path := args[0]
+		if _, statErr := os.Stat(path); os.IsNotExist(statErr) && !filepath.IsAbs(path) {
+			path = filepath.Join(cfg.ConfigDir, path)
+		}
+		var raw []byte
+		raw, err = os.ReadFile(path)
+		if err == nil {
+			data, err = stack.RenderTemplate(filepath.Base(path), raw, cfg.Env)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Print(string(data))
+	return err
+}
+
+func runConfigDrift(cmd *cobra.Command, args []string) error {
+	showDiff, _ := cmd.Flags().GetBool("diff")
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+
+	changes, err := stack.PlanConfigFiles(cfg.ConfigDir, cfg.DataFolder, cfg.Env, nil)
+	if err != nil {
+		return err
+	}
+
+	drifted := make([]stack.ConfigFileChange, 0, len(changes))
+	for _, c := range changes {
+		if c.Status == stack.ConfigFileChanged || c.Status == stack.ConfigFileNew {
+			if !showDiff {
+				c.Diff = ""
+			}
+			drifted = append(drifted, c)
+		}
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(drifted, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(drifted) == 0 {
+		color.Green("All deployed config files match their source")
+		return nil
+	}
+
+	for _, c := range drifted {
+		if c.Status == stack.ConfigFileNew {
+			color.Yellow("  %s -> %s (not deployed)", c.Source, c.Destination)
+			continue
+		}
+		color.Yellow("  %s -> %s (differs)", c.Source, c.Destination)
+		if showDiff {
+			stack.PrintDiff(c.Diff, "      ")
+		}
+	}
+	fmt.Printf("\n%d of %d config files drifted\n", len(drifted), len(changes))
+	return nil
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/spf13/cobra"
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <src> <dst>",
+	Short: "Copy files between the host and a service container",
+	Long: `Copy files or directories between the host and a service container.
+
+Use service:path to refer to a path inside a container, e.g.
+
+  mediastack cp sonarr:/config/config.xml ./config.xml
+  mediastack cp ./custom-scripts plex:/config/scripts
+
+File modes are preserved. Use --chown to set ownership of the copied
+files to PUID/PGID.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runCp,
+}
+
+func init() {
+	cpCmd.Flags().Bool("chown", false, "Set ownership of copied files to PUID:PGID")
+}
+
+func runCp(cmd *cobra.Command, args []string) error {
+	chown, _ := cmd.Flags().GetBool("chown")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if dryRun {
+		color.Cyan("[dry-run] Would copy %s -> %s", args[0], args[1])
+		return nil
+	}
+
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer client.Close()
+
+	opts := docker.CopyOptions{
+		Chown: chown,
+		UID:   cfg.PUID,
+		GID:   cfg.PGID,
+	}
+
+	stats, err := client.Copy(ctx, args[0], args[1], opts)
+	if err != nil {
+		return err
+	}
+	if stats.ChownFailed > 0 {
+		color.Yellow("Warning: Could not set ownership of %d files", stats.ChownFailed)
+	}
+
+	color.Green("Copied %s -> %s", args[0], args[1])
+	return nil
+}
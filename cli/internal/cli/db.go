@@ -0,0 +1,409 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/database"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the stack's PostgreSQL databases",
+}
+
+var dbInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create the Authentik and Guacamole databases",
+	Long: `Initialise the shared PostgreSQL server by running psql inside the
+postgresql container:
+
+- Create the AUTHENTIK_DATABASE and GUACAMOLE_DATABASE databases
+- Load the Guacamole schema from the Guacamole image
+- Grant POSTGRESQL_USERNAME access to both databases
+
+Steps that are already applied are skipped, so it is safe to run again.
+Use --dry-run to print the SQL (with the password masked) instead.`,
+	RunE: runDBInit,
+}
+
+var dbDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Dump the PostgreSQL databases to an archive",
+	Long: `Dump databases from the postgresql container with pg_dump.
+
+Each database is written in pg_dump's compressed custom format and
+streamed out of the container into a single archive, together with
+metadata recording the postgres image, server version, databases and
+time of the dump. By default every user database is dumped to
+db-dumps/mediastack-db-<timestamp>.tar in the config directory.`,
+	RunE: runDBDump,
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restore PostgreSQL databases from a dump archive",
+	Long: `Restore databases from an archive made by 'mediastack db dump'.
+
+Services that depend on postgresql are stopped while the databases are
+dropped, recreated and loaded with pg_restore, then started again. A
+warning is shown when the dump was made by a different major version of
+PostgreSQL than the one running now.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDBRestore,
+}
+
+func init() {
+	dbCmd.AddCommand(dbInitCmd)
+	dbCmd.AddCommand(dbDumpCmd)
+	dbCmd.AddCommand(dbRestoreCmd)
+
+	dbDumpCmd.Flags().StringSlice("database", nil, "Database to dump (repeatable, default all)")
+	dbDumpCmd.Flags().StringP("output", "o", "", "Archive to write (default db-dumps/mediastack-db-<timestamp>.tar)")
+
+	dbRestoreCmd.Flags().StringSlice("database", nil, "Database to restore (repeatable, default all in the dump)")
+	dbRestoreCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+}
+
+func runDBInit(cmd *cobra.Command, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if !dryRun {
+		release, err := acquireStackLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer client.Close()
+
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+
+	initCfg, err := databaseInitConfig(ctx, compose)
+	if err != nil {
+		return err
+	}
+
+	pg, err := database.NewPostgres(ctx, client, initCfg.User, initCfg.Password)
+	if err != nil {
+		if !dryRun {
+			return err
+		}
+		color.Yellow("-- Warning: %v; showing every step", err)
+		pg = nil
+	}
+
+	steps, err := database.PlanInit(ctx, pg, initCfg)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		printInitSteps(steps)
+		return nil
+	}
+
+	return runDatabaseInit(ctx, pg, initCfg, steps)
+}
+
+func runDBDump(cmd *cobra.Command, args []string) error {
+	databases, _ := cmd.Flags().GetStringSlice("database")
+	output, _ := cmd.Flags().GetString("output")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+
+	if output == "" {
+		output = filepath.Join(cfg.ConfigDir, dumpDir, "mediastack-db-"+time.Now().Format("20060102-150405")+".tar")
+	}
+
+	pg, client, err := connectPostgres(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if len(databases) == 0 {
+		databases, err = pg.ListDatabases(ctx)
+		if err != nil {
+			return err
+		}
+		if len(databases) == 0 {
+			return fmt.Errorf("no databases to dump")
+		}
+	}
+
+	if dryRun {
+		color.Cyan("[dry-run] Would dump %s to %s", strings.Join(databases, ", "), output)
+		return nil
+	}
+
+	color.Cyan("Dumping %s...", strings.Join(databases, ", "))
+	meta, err := database.Dump(ctx, pg, cfg.ProjectName, databases, output)
+	if err != nil {
+		return err
+	}
+
+	size := ""
+	if info, err := os.Stat(output); err == nil {
+		size = " (" + formatBytes(uint64(info.Size())) + ")"
+	}
+	color.Green("Dumped %d database(s) from PostgreSQL %s to %s%s", len(meta.Databases), meta.ServerVersion, output, size)
+	return nil
+}
+
+func runDBRestore(cmd *cobra.Command, args []string) error {
+	databases, _ := cmd.Flags().GetStringSlice("database")
+	yes, _ := cmd.Flags().GetBool("yes")
+	path := args[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+
+	meta, err := database.ReadDumpMetadata(path)
+	if err != nil {
+		return err
+	}
+
+	if len(databases) == 0 {
+		databases = meta.Databases
+	}
+	for _, db := range databases {
+		if !slices.Contains(meta.Databases, db) {
+			return fmt.Errorf("database %q is not in %s (has %s)", db, path, strings.Join(meta.Databases, ", "))
+		}
+	}
+
+	fmt.Printf("Dump:      %s\n", path)
+	fmt.Printf("Created:   %s\n", meta.Created.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("Server:    PostgreSQL %s (%s)\n", meta.ServerVersion, meta.Image)
+	fmt.Printf("Databases: %s\n", strings.Join(databases, ", "))
+
+	if !dryRun {
+		release, err := acquireStackLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	pg, client, err := connectPostgres(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if version, err := pg.ServerVersion(ctx); err == nil {
+		if current := database.MajorVersion(version); current != meta.MajorVersion() {
+			color.Yellow("Warning: The dump is from PostgreSQL %s but the server runs %s; the restore may fail", meta.MajorVersion(), current)
+		}
+	}
+
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+
+	dependents, err := runningDependents(ctx, compose, database.Service)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		if len(dependents) > 0 {
+			color.Cyan("[dry-run] Would stop %s", strings.Join(dependents, ", "))
+		}
+		color.Cyan("[dry-run] Would drop and restore %s", strings.Join(databases, ", "))
+		return nil
+	}
+
+	if !yes {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("restore replaces the existing databases; use --yes to confirm")
+		}
+		if !confirm(fmt.Sprintf("Replace %s with the contents of the dump?", strings.Join(databases, ", "))) {
+			return fmt.Errorf("restore cancelled")
+		}
+	}
+
+	if len(dependents) > 0 {
+		// Start them again even if the restore timed out or was interrupted
+		defer func() {
+			startCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			defer cancel()
+
+			color.Cyan("Starting %s...", strings.Join(dependents, ", "))
+			for _, service := range dependents {
+				if err := compose.StartService(startCtx, service); err != nil {
+					color.Red("Failed to start %s: %v", service, err)
+				}
+			}
+		}()
+
+		color.Cyan("Stopping %s...", strings.Join(dependents, ", "))
+		for _, service := range dependents {
+			if err := compose.StopService(ctx, service); err != nil {
+				return fmt.Errorf("failed to stop %s: %w", service, err)
+			}
+		}
+	}
+
+	err = database.Restore(ctx, pg, path, databases, func(db string) {
+		color.Cyan("  Restoring %s...", db)
+	})
+	if err != nil {
+		return err
+	}
+
+	color.Green("Restored %d database(s)", len(databases))
+	return nil
+}
+
+// dumpDir is where db dump writes archives, relative to the config directory
+const dumpDir = "db-dumps"
+
+// connectPostgres connects to the running postgresql container with the
+// credentials from .env
+func connectPostgres(ctx context.Context) (*database.Postgres, *docker.Client, error) {
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+
+	initCfg, err := database.InitConfigFromEnv(cfg.Env, "")
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+
+	pg, err := database.NewPostgres(ctx, client, initCfg.User, initCfg.Password)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return pg, client, nil
+}
+
+// runningDependents returns the running services that depend on service
+func runningDependents(ctx context.Context, compose *docker.Compose, service string) ([]string, error) {
+	project, err := compose.Model(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load compose model: %w", err)
+	}
+
+	var running []string
+	for _, name := range project.Dependents(service) {
+		if ok, err := compose.IsRunning(ctx, name); err == nil && ok {
+			running = append(running, name)
+		}
+	}
+	return running, nil
+}
+
+// databaseInitConfig reads the database settings from .env and the
+// Guacamole image from the compose model
+func databaseInitConfig(ctx context.Context, compose *docker.Compose) (database.InitConfig, error) {
+	image := ""
+	if project, err := compose.Model(ctx); err == nil {
+		image = project.Services["guacamole"].Image
+	}
+	return database.InitConfigFromEnv(cfg.Env, image)
+}
+
+// runDatabaseInit applies the pending steps with progress output
+func runDatabaseInit(ctx context.Context, pg *database.Postgres, initCfg database.InitConfig, steps []database.Step) error {
+	err := database.RunInit(ctx, pg, initCfg, steps, func(step database.Step) {
+		color.Cyan("  %s...", step.Description)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if step.Done {
+			fmt.Printf("  %s (already done)\n", step.Description)
+		}
+	}
+	color.Green("Databases initialised")
+	return nil
+}
+
+// printInitSteps prints the SQL of each pending step
+func printInitSteps(steps []database.Step) {
+	for _, step := range steps {
+		if step.Done {
+			fmt.Printf("-- %s: already done\n\n", step.Description)
+			continue
+		}
+		fmt.Printf("-- %s\n\\connect %s\n%s\n", step.Description, database.QuoteIdent(step.Database), step.Display)
+	}
+}
+
+// offerDatabaseInit runs db init after a deploy when the postgresql
+// service is up but its databases are not initialised. Without autoInit
+// the user is asked first, or told to run db init when not at a terminal.
+func offerDatabaseInit(ctx context.Context, compose *docker.Compose, client *docker.Client, autoInit bool) {
+	initCfg, err := databaseInitConfig(ctx, compose)
+	if err != nil {
+		return
+	}
+	pg, err := database.NewPostgres(ctx, client, initCfg.User, initCfg.Password)
+	if err != nil {
+		return
+	}
+	steps, err := database.PlanInit(ctx, pg, initCfg)
+	if err != nil {
+		color.Yellow("  Warning: Could not check databases: %v", err)
+		return
+	}
+	if !database.NeedsInit(steps) {
+		return
+	}
+
+	color.Yellow("\nThe Authentik / Guacamole databases are not initialised.")
+	if !autoInit {
+		if !interactive || !isTerminal(os.Stdin) {
+			color.Yellow("  Run 'mediastack db init' to create them.")
+			return
+		}
+		if !confirm("  Run 'mediastack db init' now?") {
+			return
+		}
+	}
+
+	if err := runDatabaseInit(ctx, pg, initCfg, steps); err != nil {
+		color.Red("  Database initialisation failed: %v", err)
+	}
+}
+
+// interactive is cleared while a command runs inside the shell, whose
+// input must not be read by prompts
+var interactive = true
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks a yes/no question on stdin, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -3,6 +3,8 @@ package cli
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
@@ -12,39 +14,79 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var deployCmd = &cobra.Command{
-	Use:     "deploy",
-	Aliases: []string{"up"},
-	Short:   "Deploy the media stack",
-	Long: `Deploy the MediaStack by performing the following steps:
+var deployCmd = newDeployCmd()
+
+// newDeployCmd builds the deploy command with its flags at their defaults
+func newDeployCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "deploy",
+		Aliases: []string{"up"},
+		Short:   "Deploy the media stack",
+		Long: `Deploy the MediaStack by performing the following steps:
 
 1. Create required directory structure
 2. Set proper file permissions
 3. Copy configuration files to data folder
 4. Validate docker-compose configuration
 5. Pull Docker images (optional)
-6. Stop any existing containers
+6. Stop services whose configuration, image or config files changed
 7. Start all services
+8. Wait until every service is running, healthy and answering its
+   Traefik service port, or --ready-timeout passes
+9. Prune unused images
 
 This command replaces the functionality of restart.sh with improved
-error handling and proper container management.`,
-	RunE: runDeploy,
-}
+error handling and proper container management.
+
+Use --plan to preview which directories would be created, which config
+files would change (with diffs) and which services would be created,
+recreated, restarted or removed, without modifying anything.
+
+Deploy fails if a service never becomes ready, unless it is listed in
+--optional. A readiness table with failure reasons and recent log lines
+is printed either way.
+
+Deployed config files that differ from their source (for example after
+editing traefik/dynamic.yaml in the data folder) are backed up to
+config-backups/ in the config directory before being overwritten. Use
+--keep-local to leave specific files alone.
+
+If postgresql is running but the Authentik or Guacamole databases have
+not been initialised, deploy offers to run 'db init' (or runs it with
+--db-init).
+
+Unchanged services keep running. Use --force to stop every container
+and recreate the whole stack.`,
+		RunE: runDeploy,
+	}
 
-func init() {
-	deployCmd.Flags().Bool("pull", false, "Pull images before deploying")
-	deployCmd.Flags().Bool("no-directories", false, "Skip directory creation")
-	deployCmd.Flags().Bool("no-files", false, "Skip config file copying")
-	deployCmd.Flags().Bool("force", false, "Force recreate all containers")
-	deployCmd.Flags().Bool("prune", true, "Prune unused resources after successful deploy")
+	cmd.Flags().BoolP("pull", "p", false, "Pull images before deploying")
+	cmd.Flags().Bool("no-directories", false, "Skip directory creation")
+	cmd.Flags().Bool("no-files", false, "Skip config file copying")
+	cmd.Flags().Bool("force", false, "Stop and recreate all containers, not just changed ones")
+	cmd.Flags().Bool("prune", true, "Prune unused resources after successful deploy")
+	cmd.Flags().Bool("plan", false, "Show what deploy would change and exit")
+	cmd.Flags().Bool("db-init", false, "Initialise the Authentik and Guacamole databases without asking")
+	cmd.Flags().StringSlice("keep-local", nil, "Config files whose deployed copy is not overwritten, e.g. traefik-dynamic.yaml")
+	cmd.Flags().Bool("global", false, "Prune resources of all projects on the host, not just this stack")
+	cmd.Flags().Duration("ready-timeout", 3*time.Minute, "How long to wait for services to become ready (0 to skip)")
+	cmd.Flags().StringSlice("optional", nil, "Services whose readiness failures are only reported")
+	addHookFlags(cmd)
+	return cmd
 }
 
-func runDeploy(cmd *cobra.Command, args []string) error {
+func runDeploy(cmd *cobra.Command, args []string) (retErr error) {
 	pullFirst, _ := cmd.Flags().GetBool("pull")
 	noDirs, _ := cmd.Flags().GetBool("no-directories")
 	noFiles, _ := cmd.Flags().GetBool("no-files")
 	force, _ := cmd.Flags().GetBool("force")
 	prune, _ := cmd.Flags().GetBool("prune")
+	global, _ := cmd.Flags().GetBool("global")
+	planOnly, _ := cmd.Flags().GetBool("plan")
+	readyTimeout, _ := cmd.Flags().GetDuration("ready-timeout")
+	optional, _ := cmd.Flags().GetStringSlice("optional")
+	keepLocal, _ := cmd.Flags().GetStringSlice("keep-local")
+	dbInit, _ := cmd.Flags().GetBool("db-init")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
@@ -54,6 +96,18 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 		cfg.Variant = config.NormalizeVariant(cfg.Variant)
 	}
 
+	keepLocal, err := resolveConfigFiles(keepLocal)
+	if err != nil {
+		return err
+	}
+
+	if planOnly {
+		return runDeployPlan(ctx, noDirs, noFiles, keepLocal)
+	}
+
+	var hooks *stack.HookSession
+	var history *historyRecorder
+
 	color.Cyan("Deploying MediaStack...")
 	color.Cyan("  Variant: %s", cfg.Variant)
 	color.Cyan("  Config:  %s", cfg.ConfigDir)
@@ -64,14 +118,38 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 	if dryRun {
 		color.Yellow("[dry-run mode - no changes will be made]")
 		fmt.Println()
+	} else {
+		release, err := acquireStackLock()
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		history = startHistory(ctx, cmd, "deploy", args)
+		defer func() { history.finish(retErr) }()
+
+		var closeLog func()
+		hooks, closeLog = newHookSession(cmd, "deploy")
+		defer closeLog()
+		defer func() { finishHooks(hooks, stack.HookPostDeploy, retErr) }()
+
+		if err := hooks.Pre(ctx, stack.HookPreDeploy); err != nil {
+			return err
+		}
 	}
 
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+
 	// Step 1: Create directories
 	if !noDirs {
 		color.Cyan("Step 1: Creating directories...")
+		dirs, err := requiredDirectories(ctx, compose)
+		if err != nil {
+			return err
+		}
 		if err := stack.CreateDirectories(
-			cfg.DataFolder,
-			cfg.MediaFolder,
+			dirs,
 			cfg.PUID,
 			cfg.PGID,
 			verbose,
@@ -95,12 +173,27 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to set permissions: %w", err)
 	}
 
+	// Record which config files will change before they are overwritten
+	fullRestart := force
+	var fileChanges []stack.ConfigFileChange
+	if !noFiles && !dryRun {
+		changes, err := stack.PlanConfigFiles(cfg.ConfigDir, cfg.DataFolder, cfg.Env, keepLocal)
+		if err != nil {
+			color.Yellow("  Warning: Could not compare config files, restarting all: %v", err)
+			fullRestart = true
+		}
+		fileChanges = changes
+		history.setConfigFiles(changes)
+	}
+
 	// Step 3: Copy config files
 	if !noFiles {
 		color.Cyan("\nStep 3: Copying configuration files...")
 		if err := stack.CopyConfigFiles(
 			cfg.ConfigDir,
 			cfg.DataFolder,
+			cfg.Env,
+			keepLocal,
 			cfg.PUID,
 			cfg.PGID,
 			verbose,
@@ -114,19 +207,34 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 
 	if dryRun {
 		color.Yellow("\n[dry-run] Would validate, pull, and start containers")
+		if client, err := docker.NewClient(cfg.ProjectName); err == nil {
+			defer client.Close()
+			var images []string
+			if prune {
+				images = []string{}
+				if project, err := compose.Model(ctx); err == nil {
+					images = project.Images()
+				}
+			}
+			if err := showPruneCandidates(ctx, client, global, images); err != nil {
+				color.Yellow("  Warning: Could not list prune candidates: %v", err)
+			}
+		}
 		return nil
 	}
 
 	// Step 4: Validate compose configuration
 	color.Cyan("\nStep 4: Validating Docker Compose configuration...")
-	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
-	compose.SetVerbose(verbose)
-
 	if err := compose.Config(ctx); err != nil {
 		return fmt.Errorf("compose configuration is invalid: %w", err)
 	}
 	color.Green("  Configuration is valid")
 
+	optional, err = compose.ResolveServices(ctx, optional)
+	if err != nil {
+		return err
+	}
+
 	// Step 5: Pull images
 	if pullFirst {
 		color.Cyan("\nStep 5: Pulling Docker images...")
@@ -138,44 +246,38 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 	}
 
 	// Step 6: Stop existing containers
-	color.Cyan("\nStep 6: Stopping existing containers...")
 	client, err := docker.NewClient(cfg.ProjectName)
 	if err != nil {
 		return fmt.Errorf("failed to create Docker client: %w", err)
 	}
 	defer client.Close()
 
-	// Get list of running containers for this project
-	containers, err := client.ListContainers(ctx, false)
-	if err != nil {
-		color.Yellow("  Warning: Could not list containers: %v", err)
-	} else if len(containers) > 0 {
-		color.Cyan("  Found %d running containers", len(containers))
-		for _, c := range containers {
-			if verbose {
-				fmt.Printf("    Stopping: %s\n", c.Name)
-			}
-			if err := client.StopContainer(ctx, c.ID); err != nil {
-				color.Yellow("    Warning: Failed to stop %s: %v", c.Name, err)
-			}
+	var plans []ServicePlan
+	if !fullRestart {
+		plans, err = planServices(ctx, compose, client, fileChanges)
+		if err != nil {
+			color.Yellow("  Warning: Could not determine changed services, restarting all: %v", err)
+			fullRestart = true
 		}
-		color.Green("  Stopped existing containers")
-	} else {
-		color.Green("  No existing containers to stop")
 	}
 
-	// Prune old containers
-	if err := client.PruneContainers(ctx); err != nil {
-		color.Yellow("  Warning: Failed to prune containers: %v", err)
+	if fullRestart {
+		color.Cyan("\nStep 6: Stopping existing containers...")
+		stopAllContainers(ctx, client)
+		history.setAllServices()
+		if services, err := compose.ConfigServices(ctx); err == nil {
+			hooks.SetServices(services)
+			history.setServices(services)
+		}
+	} else {
+		color.Cyan("\nStep 6: Stopping changed services...")
+		stopChangedServices(ctx, client, plans)
+		hooks.SetServices(changedServices(plans))
+		history.setServices(changedServices(plans))
 	}
 
-	// Prune volumes and networks
-	if err := client.PruneVolumes(ctx); err != nil {
-		color.Yellow("  Warning: Failed to prune volumes: %v", err)
-	}
-	if err := client.PruneNetworks(ctx); err != nil {
-		color.Yellow("  Warning: Failed to prune networks: %v", err)
-	}
+	// Prune old containers, volumes and networks
+	pruneResources(ctx, client, global, "  ")
 
 	// Step 7: Start services
 	color.Cyan("\nStep 7: Starting services...")
@@ -183,41 +285,26 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to start services: %w", err)
 	}
 
-	// Step 8: Verify services are running
-	color.Cyan("\nStep 8: Verifying services...")
-	time.Sleep(5 * time.Second) // Give containers time to start
-
-	services, err := compose.ConfigServices(ctx)
-	if err != nil {
-		color.Yellow("  Warning: Could not get service list: %v", err)
-	} else {
-		running := 0
-		failed := 0
-
-		for _, service := range services {
-			isRunning, err := compose.IsRunning(ctx, service)
-			if err != nil || !isRunning {
-				color.Red("  Not running: %s", service)
-				failed++
-			} else {
-				running++
-			}
-		}
-
-		if failed > 0 {
-			color.Yellow("  %d/%d services running", running, len(services))
-		} else {
-			color.Green("  All %d services running", running)
+	// Step 8: Wait for services to become ready
+	if readyTimeout > 0 {
+		color.Cyan("\nStep 8: Verifying services are ready...")
+		if err := verifyReadiness(ctx, compose, client, readyTimeout, optional); err != nil {
+			return err
 		}
+	} else {
+		color.Yellow("\nStep 8: Skipping readiness verification (--ready-timeout 0)")
 	}
 
+	// Offer to create the databases the first time postgresql is up
+	offerDatabaseInit(ctx, compose, client, dbInit)
+
 	// Step 9: Prune unused images
 	if prune {
 		color.Cyan("\nStep 9: Pruning unused images...")
-		if err := client.PruneImages(ctx); err != nil {
+		if project, err := compose.Model(ctx); err != nil {
 			color.Yellow("  Warning: Failed to prune images: %v", err)
 		} else {
-			color.Green("  Unused images removed")
+			pruneImages(ctx, client, project.Images(), global, "  ")
 		}
 	}
 
@@ -226,3 +313,123 @@ func runDeploy(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// runDeployPlan prints the deploy plan without modifying anything
+func runDeployPlan(ctx context.Context, noDirs, noFiles bool, keepLocal []string) error {
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer client.Close()
+
+	plan, err := computeDeployPlan(ctx, compose, client, noDirs, noFiles, keepLocal)
+	if err != nil {
+		return fmt.Errorf("failed to compute deploy plan: %w", err)
+	}
+
+	printDeployPlan(plan)
+	return nil
+}
+
+// requiredDirectories returns the directories the services of the active
+// variant mount, from the rendered compose model
+func requiredDirectories(ctx context.Context, compose *docker.Compose) (*stack.Directories, error) {
+	project, err := compose.Model(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render compose model: %w", err)
+	}
+	return stack.RequiredDirectories(project, cfg.DataFolder, cfg.MediaFolder), nil
+}
+
+// serviceDataDirectories returns the top-level service directories in
+// FOLDER_FOR_DATA. Without a compose model, e.g. when docker is not
+// running, every directory in FOLDER_FOR_DATA is used instead.
+func serviceDataDirectories(ctx context.Context) []string {
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	dirs, err := requiredDirectories(ctx, compose)
+	if err == nil {
+		return dirs.ServiceDataDirectories()
+	}
+	if verbose {
+		color.Yellow("Warning: %v; using every directory in %s", err, cfg.DataFolder)
+	}
+
+	entries, _ := os.ReadDir(cfg.DataFolder)
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+// stopAllContainers stops every running project container
+func stopAllContainers(ctx context.Context, client *docker.Client) {
+	containers, err := client.ListContainers(ctx, false)
+	if err != nil {
+		color.Yellow("  Warning: Could not list containers: %v", err)
+		return
+	}
+	if len(containers) == 0 {
+		color.Green("  No existing containers to stop")
+		return
+	}
+
+	color.Cyan("  Found %d running containers", len(containers))
+	for _, c := range containers {
+		if verbose {
+			fmt.Printf("    Stopping: %s\n", c.Name)
+		}
+		if err := client.StopContainer(ctx, c.ID); err != nil {
+			color.Yellow("    Warning: Failed to stop %s: %v", c.Name, err)
+		}
+	}
+	color.Green("  Stopped existing containers")
+}
+
+// stopChangedServices stops only the running services that the plan
+// recreates or restarts, leaving everything else running
+func stopChangedServices(ctx context.Context, client *docker.Client, plans []ServicePlan) {
+	for _, sp := range plans {
+		if sp.Action != ActionUnchanged && (verbose || sp.Action != ActionStart) {
+			fmt.Printf("  %-24s %s %s\n", sp.Service, getActionColor(sp.Action)("%-10s", sp.Action), strings.Join(sp.Reasons, "; "))
+		}
+	}
+
+	services := servicesToStop(plans)
+	if len(services) == 0 {
+		color.Green("  No running services need to be stopped")
+		return
+	}
+
+	containers, err := client.ListContainers(ctx, false)
+	if err != nil {
+		color.Yellow("  Warning: Could not list containers: %v", err)
+		return
+	}
+
+	stop := make(map[string]bool, len(services))
+	for _, s := range services {
+		stop[s] = true
+	}
+
+	stopped := 0
+	for _, c := range containers {
+		if !stop[c.Service] {
+			continue
+		}
+		if verbose {
+			fmt.Printf("    Stopping: %s\n", c.Name)
+		}
+		if err := client.StopContainer(ctx, c.ID); err != nil {
+			color.Yellow("    Warning: Failed to stop %s: %v", c.Name, err)
+			continue
+		}
+		stopped++
+	}
+	color.Green("  Stopped %d changed service(s), %d left running", stopped, len(containers)-stopped)
+}
@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var duCmd = &cobra.Command{
+	Use:   "du",
+	Short: "Show disk usage per service",
+	Long: `Report disk usage for the media stack.
+
+Combines Docker's disk usage data for project images, container layers
+and logs with a size walk of each service's directory under
+FOLDER_FOR_DATA and the download directories under FOLDER_FOR_MEDIA.
+Results are sorted by size with totals.`,
+	RunE: runDu,
+}
+
+func init() {
+	duCmd.Flags().Bool("json", false, "Output as JSON")
+	duCmd.Flags().Int("parallel", 8, "Number of directories to scan at once")
+	duCmd.Flags().Bool("no-docker", false, "Skip Docker image and container usage")
+}
+
+// DiskUsageReport is the combined output of the du command
+type DiskUsageReport struct {
+	Docker        []docker.ServiceDiskUsage `json:"docker,omitempty"`
+	Data          []stack.DirUsage          `json:"data"`
+	Downloads     []stack.DirUsage          `json:"downloads"`
+	DockerTotal   int64                     `json:"docker_total"`
+	DataTotal     int64                     `json:"data_total"`
+	DownloadTotal int64                     `json:"download_total"`
+}
+
+func runDu(cmd *cobra.Command, args []string) error {
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	noDocker, _ := cmd.Flags().GetBool("no-docker")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if !jsonOutput {
+		color.Cyan("Measuring disk usage...")
+	}
+
+	var report DiskUsageReport
+	var dockerErr error
+	var wg sync.WaitGroup
+
+	// Docker's disk usage query can be slow, so run it alongside the walks
+	if !noDocker {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := docker.NewClient(cfg.ProjectName)
+			if err != nil {
+				dockerErr = err
+				return
+			}
+			defer client.Close()
+			report.Docker, dockerErr = client.ProjectDiskUsage(ctx)
+		}()
+	}
+
+	report.Data = stack.MeasureDirectories(cfg.DataFolder, serviceDataDirectories(ctx), parallel)
+	report.Downloads = stack.MeasureDirectories(cfg.MediaFolder, stack.DownloadDirectories(), parallel)
+	wg.Wait()
+
+	sort.Slice(report.Docker, func(i, j int) bool {
+		return report.Docker[i].Total() > report.Docker[j].Total()
+	})
+	for _, u := range report.Docker {
+		report.DockerTotal += u.Total()
+	}
+	for _, u := range report.Data {
+		report.DataTotal += u.Size
+	}
+	for _, u := range report.Downloads {
+		report.DownloadTotal += u.Size
+	}
+
+	if dockerErr != nil {
+		color.Yellow("Warning: Could not get Docker disk usage: %v", dockerErr)
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if !noDocker && dockerErr == nil {
+		outputDockerUsage(report.Docker, report.DockerTotal)
+	}
+	outputDirUsage(fmt.Sprintf("Service data (%s)", cfg.DataFolder), report.Data, report.DataTotal)
+	outputDirUsage(fmt.Sprintf("Downloads (%s)", cfg.MediaFolder), report.Downloads, report.DownloadTotal)
+
+	fmt.Println()
+	color.Cyan("Total: %s", formatBytes(uint64(report.DockerTotal+report.DataTotal+report.DownloadTotal)))
+	return nil
+}
+
+func outputDockerUsage(usage []docker.ServiceDiskUsage, total int64) {
+	fmt.Printf("\nDocker (%s)\n\n", cfg.ProjectName)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Service", "Image", "Image Size", "Unique", "Writable", "Logs", "Total"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	for _, u := range usage {
+		logs := "n/a"
+		if u.LogSize >= 0 {
+			logs = formatBytes(uint64(u.LogSize))
+		}
+		table.Append([]string{
+			u.Service,
+			truncateString(u.Image, 40),
+			formatBytes(uint64(u.ImageSize)),
+			formatBytes(uint64(u.ImageUniqueSize)),
+			formatBytes(uint64(u.WritableSize)),
+			logs,
+			formatBytes(uint64(u.Total())),
+		})
+	}
+
+	table.SetFooter([]string{"", "", "", "", "", "Total", formatBytes(uint64(total))})
+	table.Render()
+}
+
+func outputDirUsage(title string, usage []stack.DirUsage, total int64) {
+	fmt.Printf("\n%s\n\n", title)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Directory", "Size", "Files"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	for _, u := range usage {
+		size := formatBytes(uint64(u.Size))
+		if u.Error != "" {
+			size += color.YellowString(" (partial)")
+		}
+		table.Append([]string{u.Name, size, fmt.Sprintf("%d", u.Files)})
+	}
+
+	table.SetFooter([]string{"Total", formatBytes(uint64(total)), ""})
+	table.Render()
+
+	if verbose {
+		for _, u := range usage {
+			if u.Error != "" {
+				color.Yellow("  Warning: %s: %s", u.Name, u.Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/spf13/cobra"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Stream container events",
+	Long: `Stream live Docker events for MediaStack containers.
+
+Shows starts, stops, exits with their exit code, OOM kills and health
+status changes as they happen. Use --since to replay recent history
+(e.g. 10m or 2024-01-01T00:00:00) before following live events.`,
+	RunE: runEvents,
+}
+
+func init() {
+	eventsCmd.Flags().Bool("json", false, "Output events as JSON lines")
+	eventsCmd.Flags().String("since", "", "Replay events since timestamp or duration (e.g., 10m)")
+}
+
+func runEvents(cmd *cobra.Command, args []string) error {
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	since, _ := cmd.Flags().GetString("since")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer client.Close()
+
+	if !jsonOutput {
+		color.Cyan("Watching events for %s (Ctrl+C to exit)...\n", cfg.ProjectName)
+	}
+
+	evs, errs := client.WatchEvents(ctx, since)
+	encoder := json.NewEncoder(os.Stdout)
+
+	for ev := range evs {
+		if jsonOutput {
+			if err := encoder.Encode(ev); err != nil {
+				return err
+			}
+			continue
+		}
+		printEvent(ev)
+	}
+
+	return <-errs
+}
+
+// printEvent prints a single event as a coloured timeline entry
+func printEvent(ev docker.Event) {
+	ts := ev.Time.Local().Format("2006-01-02 15:04:05")
+	service := ev.Service
+	if service == "" {
+		service = ev.ContainerID
+	}
+
+	detail := ""
+	switch ev.Action {
+	case "die":
+		detail = fmt.Sprintf("exit code %s", ev.ExitCode)
+	case "health_status":
+		detail = ev.Health
+	}
+
+	actionColor := getEventColor(ev)
+	fmt.Printf("%s  %-24s %s %s\n", ts, service, actionColor("%-14s", ev.Action), detail)
+}
+
+func getEventColor(ev docker.Event) func(format string, a ...interface{}) string {
+	switch ev.Action {
+	case "start", "unpause":
+		return color.GreenString
+	case "die":
+		if ev.ExitCode == "0" {
+			return color.YellowString
+		}
+		return color.RedString
+	case "oom", "kill":
+		return color.New(color.FgRed, color.Bold).SprintfFunc()
+	case "stop", "restart", "pause":
+		return color.YellowString
+	case "health_status":
+		return getHealthColor(ev.Health)
+	default:
+		return color.CyanString
+	}
+}
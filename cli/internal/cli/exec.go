@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/spf13/cobra"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec [service...] -- <command> [args...]",
+	Short: "Run a command across services",
+	Long: `Run a command in one or more service containers concurrently.
+
+Services are listed before "--" and the command after it. Use --all to
+run in every service of the stack. Stdout, stderr and exit codes are
+collected per service; services that are not running are reported.
+
+Examples:
+  mediastack exec --all -- id
+  mediastack exec sonarr radarr -- df -h /data
+  mediastack exec --all --json -- curl -s ifconfig.me`,
+	RunE: runExec,
+}
+
+func init() {
+	execCmd.Flags().Bool("all", false, "Run in all services")
+	execCmd.Flags().Bool("json", false, "Output as JSON")
+	execCmd.Flags().Int("parallel", 8, "Number of services to run in at once")
+	execCmd.Flags().Duration("timeout", 30*time.Second, "Timeout per service")
+}
+
+func runExec(cmd *cobra.Command, args []string) error {
+	all, _ := cmd.Flags().GetBool("all")
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	dash := cmd.ArgsLenAtDash()
+	if dash < 0 || dash == len(args) {
+		return fmt.Errorf("usage: mediastack exec [service...] -- <command> [args...]")
+	}
+	names, command := args[:dash], args[dash:]
+
+	if all == (len(names) > 0) {
+		return fmt.Errorf("specify either --all or a list of services")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+
+	var services []string
+	var err error
+	if all {
+		services, err = compose.ConfigServices(ctx)
+	} else {
+		services, err = compose.ResolveServices(ctx, names)
+	}
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		color.Cyan("[dry-run] Would run %q in: %s", strings.Join(command, " "), strings.Join(services, ", "))
+		return nil
+	}
+
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer client.Close()
+
+	results, err := client.ExecServices(ctx, services, command, parallel, timeout)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		outputExecReport(results)
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("command failed in %d service(s)", failed)
+	}
+
+	return nil
+}
+
+func outputExecReport(results []docker.ServiceExecResult) {
+	succeeded, failed, skipped := 0, 0, 0
+
+	for _, r := range results {
+		switch {
+		case r.Skipped():
+			skipped++
+			color.Yellow("== %s: %s (%s)", r.Service, r.Error, r.State)
+			continue
+		case r.Error != "":
+			failed++
+			color.Red("== %s: %s", r.Service, r.Error)
+			continue
+		case r.ExitCode != 0:
+			failed++
+			color.Red("== %s (exit %d, %s)", r.Service, r.ExitCode, r.Duration.Round(time.Millisecond))
+		default:
+			succeeded++
+			color.Green("== %s (exit 0, %s)", r.Service, r.Duration.Round(time.Millisecond))
+		}
+
+		if out := strings.TrimRight(r.Stdout, "\n"); out != "" {
+			fmt.Println(out)
+		}
+		if errOut := strings.TrimRight(r.Stderr, "\n"); errOut != "" {
+			color.Red("%s", errOut)
+		}
+		fmt.Println()
+	}
+
+	failedText := fmt.Sprintf("Failed: %d", failed)
+	if failed > 0 {
+		failedText = color.RedString("Failed: %d", failed)
+	}
+	skippedText := fmt.Sprintf("Not running: %d", skipped)
+	if skipped > 0 {
+		skippedText = color.YellowString("Not running: %d", skipped)
+	}
+	fmt.Printf("\nTotal: %d | %s | %s | %s\n",
+		len(results), color.GreenString("Succeeded: %d", succeeded), failedText, skippedText)
+}
@@ -0,0 +1,291 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the journal of stack operations",
+	Long: `Show who deployed, stopped, restarted or pulled the stack and when.
+
+Every operation is journaled in history.jsonl in the config directory with
+its user, flags, variant, service image IDs before and after, changed
+config files, duration and outcome. Use 'history show <id>' for the full
+entry.`,
+	RunE: runHistory,
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show one journal entry in detail",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runHistoryShow,
+}
+
+func init() {
+	historyCmd.PersistentFlags().Bool("json", false, "Output as JSON")
+	historyCmd.Flags().IntP("limit", "n", 20, "Number of most recent entries to show (0 for all)")
+	historyCmd.AddCommand(historyShowCmd)
+}
+
+// historyRecorder journals one stack operation
+type historyRecorder struct {
+	entry  *stack.HistoryEntry
+	images func(ctx context.Context) map[string]string
+}
+
+// startHistory begins a journal entry for the running command, recording
+// the flags that were set and the current service images
+func startHistory(ctx context.Context, cmd *cobra.Command, operation string, args []string) *historyRecorder {
+	var flags []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		flags = append(flags, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+	})
+
+	r := &historyRecorder{
+		entry:  stack.NewHistoryEntry(operation, cfg.Variant, args, flags),
+		images: serviceImageIDs,
+	}
+	r.entry.ImagesBefore = r.images(ctx)
+	return r
+}
+
+// trackPulledImages records the images the services' image references
+// point to instead of their containers' images, so an operation that pulls
+// without recreating containers shows the images it fetched
+func (r *historyRecorder) trackPulledImages(ctx context.Context, compose *docker.Compose) {
+	if r == nil {
+		return
+	}
+	r.images = func(ctx context.Context) map[string]string {
+		return pulledImageIDs(ctx, compose)
+	}
+	r.entry.ImagesBefore = r.images(ctx)
+}
+
+// setServices records the services the operation changed
+func (r *historyRecorder) setServices(services []string) {
+	if r != nil {
+		r.entry.Services = services
+	}
+}
+
+// setAllServices records that the operation applied to the whole stack
+func (r *historyRecorder) setAllServices() {
+	if r != nil {
+		r.entry.AllServices = true
+	}
+}
+
+// setConfigFiles records the config files that are new or changed
+func (r *historyRecorder) setConfigFiles(changes []stack.ConfigFileChange) {
+	if r == nil {
+		return
+	}
+	for _, c := range changes {
+		if c.Status == stack.ConfigFileNew || c.Status == stack.ConfigFileChanged {
+			r.entry.ConfigFiles = append(r.entry.ConfigFiles, c.Destination)
+		}
+	}
+}
+
+// finish records the outcome and appends the entry to the journal
+func (r *historyRecorder) finish(opErr error) {
+	if r == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	r.entry.Finish(opErr)
+	r.entry.ImagesAfter = r.images(ctx)
+	if err := stack.AppendHistory(cfg.ConfigDir, r.entry); err != nil {
+		color.Yellow("Warning: Could not record history: %v", err)
+	}
+}
+
+// serviceImageIDs returns the container image ID of each service, or nil
+// if Docker cannot be reached
+func serviceImageIDs(ctx context.Context) map[string]string {
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return nil
+	}
+	defer client.Close()
+
+	images, err := client.ServiceImageIDs(ctx)
+	if err != nil {
+		return nil
+	}
+	return images
+}
+
+// pulledImageIDs returns the local image ID of each service's image
+// reference, or nil if Docker or the compose model cannot be reached
+func pulledImageIDs(ctx context.Context, compose *docker.Compose) map[string]string {
+	project, err := compose.Model(ctx)
+	if err != nil {
+		return nil
+	}
+
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return nil
+	}
+	defer client.Close()
+
+	images, err := client.ImageRefIDs(ctx, project)
+	if err != nil {
+		return nil
+	}
+	return images
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	limit, _ := cmd.Flags().GetInt("limit")
+
+	entries, err := stack.ReadHistory(cfg.ConfigDir)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	if jsonOutput {
+		if entries == nil {
+			entries = []stack.HistoryEntry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(entries) == 0 {
+		color.Yellow("No operations recorded")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Time", "User", "Operation", "Services", "Images", "Duration", "Outcome"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	// Newest first
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		outcome := color.GreenString(e.Outcome)
+		if e.Outcome != "success" {
+			outcome = color.RedString(e.Outcome)
+		}
+		table.Append([]string{
+			strconv.Itoa(e.ID),
+			e.Time.Local().Format("2006-01-02 15:04"),
+			e.User,
+			e.Operation,
+			truncateString(e.ServicesSummary(), 30),
+			strconv.Itoa(len(e.ChangedImages())),
+			e.Duration.Round(time.Second).String(),
+			outcome,
+		})
+	}
+
+	table.Render()
+	return nil
+}
+
+func runHistoryShow(cmd *cobra.Command, args []string) error {
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid history ID: %s", args[0])
+	}
+
+	entry, err := stack.FindHistory(cfg.ConfigDir, id)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	color.Cyan("Operation %d: %s", entry.ID, entry.Operation)
+	fmt.Printf("  Time:      %s\n", entry.Time.Local().Format("2006-01-02 15:04:05 MST"))
+	fmt.Printf("  User:      %s@%s\n", entry.User, entry.Host)
+	fmt.Printf("  Variant:   %s\n", entry.Variant)
+	if len(entry.Args) > 0 {
+		fmt.Printf("  Args:      %s\n", strings.Join(entry.Args, " "))
+	}
+	if len(entry.Flags) > 0 {
+		fmt.Printf("  Flags:     %s\n", strings.Join(entry.Flags, " "))
+	}
+	switch {
+	case entry.AllServices:
+		fmt.Printf("  Services:  all\n")
+	case len(entry.Services) > 0:
+		fmt.Printf("  Services:  %s\n", strings.Join(entry.Services, ", "))
+	}
+	fmt.Printf("  Duration:  %s\n", entry.Duration)
+	if entry.Outcome == "success" {
+		fmt.Printf("  Outcome:   %s\n", color.GreenString(entry.Outcome))
+	} else {
+		fmt.Printf("  Outcome:   %s\n", color.RedString(entry.Outcome))
+		fmt.Printf("  Error:     %s\n", entry.Error)
+	}
+
+	if len(entry.ConfigFiles) > 0 {
+		color.Cyan("\nChanged config files:")
+		for _, f := range entry.ConfigFiles {
+			fmt.Printf("  %s\n", f)
+		}
+	}
+
+	changed := entry.ChangedImages()
+	if len(changed) > 0 {
+		color.Cyan("\nImage changes:")
+		for _, service := range changed {
+			fmt.Printf("  %-24s %s -> %s\n", service,
+				shortImageID(entry.ImagesBefore[service]), shortImageID(entry.ImagesAfter[service]))
+		}
+	}
+
+	return nil
+}
+
+// shortImageID trims an image ID to the 12 characters Docker displays
+func shortImageID(id string) string {
+	if id == "" {
+		return "(none)"
+	}
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
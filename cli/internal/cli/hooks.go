@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/spf13/cobra"
+)
+
+// addHookFlags registers the hook flags on an operation command
+func addHookFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("no-hooks", false, "Do not run hook scripts")
+	cmd.Flags().Duration("hook-timeout", stack.DefaultHookTimeout, "Timeout for each hook script")
+}
+
+// newHookSession returns a hook session for the operation, or nil when
+// hooks are disabled. Hook output goes to stdout and the deploy log; the
+// returned function closes the log.
+func newHookSession(cmd *cobra.Command, operation string) (*stack.HookSession, func()) {
+	noHooks, _ := cmd.Flags().GetBool("no-hooks")
+	timeout, _ := cmd.Flags().GetDuration("hook-timeout")
+	if noHooks {
+		return nil, func() {}
+	}
+
+	env := stack.HookEnv{
+		Operation: operation,
+		Variant:   cfg.Variant,
+		Project:   cfg.ProjectName,
+		ConfigDir: cfg.ConfigDir,
+	}
+
+	var output io.Writer = os.Stdout
+	closeLog := func() {}
+	if logFile, err := stack.OpenDeployLog(cfg.ConfigDir); err != nil {
+		color.Yellow("Warning: Could not open deploy log: %v", err)
+	} else {
+		output = io.MultiWriter(os.Stdout, logFile)
+		closeLog = func() { logFile.Close() }
+	}
+
+	return stack.NewHookSession(env, timeout, output), closeLog
+}
+
+// finishHooks runs the post or on-failure hook. Failures are reported but
+// do not change the outcome of the operation.
+func finishHooks(hooks *stack.HookSession, post stack.Hook, opErr error) {
+	ctx := context.Background()
+	if err := hooks.Finish(ctx, post, opErr); err != nil {
+		color.Yellow("Warning: %v", err)
+	}
+}
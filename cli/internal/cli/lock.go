@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/spf13/cobra"
+)
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Remove the stack operation lock",
+	Long: `Remove the advisory lock that prevents concurrent deploy, stop,
+restart and pull operations.
+
+Without --force only stale locks (left by a process on this host that
+is no longer running) are removed.`,
+	RunE: runUnlock,
+}
+
+func init() {
+	unlockCmd.Flags().Bool("force", false, "Remove the lock even if its holder may still be running")
+}
+
+// acquireStackLock takes the stack lock for the running command. The
+// returned function releases it.
+func acquireStackLock() (func(), error) {
+	lock, err := stack.AcquireLock(cfg.ConfigDir, strings.Join(os.Args, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := lock.Release(); err != nil {
+			color.Yellow("Warning: %v", err)
+		}
+	}, nil
+}
+
+func runUnlock(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+
+	holder, err := stack.ReadLock(cfg.ConfigDir)
+	if err != nil && !force {
+		return err
+	}
+
+	if holder == nil && err == nil {
+		color.Green("Stack is not locked")
+		return nil
+	}
+
+	if holder != nil && !holder.Stale() && !force {
+		return fmt.Errorf("lock held by %s\nUse --force to remove it anyway", holder)
+	}
+
+	if dryRun {
+		color.Cyan("[dry-run] Would remove lock")
+		return nil
+	}
+
+	if err := stack.RemoveLock(cfg.ConfigDir); err != nil {
+		return err
+	}
+
+	if holder != nil {
+		color.Green("Removed lock held by %s", holder)
+	} else {
+		color.Green("Removed lock")
+	}
+	return nil
+}
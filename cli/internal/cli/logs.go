@@ -37,7 +37,11 @@ func runLogs(cmd *cobra.Command, args []string) error {
 
 	service := ""
 	if len(args) > 0 {
-		service = args[0]
+		services, err := compose.ResolveServices(ctx, args[:1])
+		if err != nil {
+			return err
+		}
+		service = services[0]
 	}
 
 	if err := compose.Logs(ctx, service, follow, tail, timestamps); err != nil {
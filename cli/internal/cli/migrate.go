@@ -0,0 +1,311 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/config"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/spf13/cobra"
+)
+
+var migrateStorageCmd = &cobra.Command{
+	Use:   "migrate-storage <data|media> [new-path]",
+	Short: "Move FOLDER_FOR_DATA or FOLDER_FOR_MEDIA to a new location",
+	Long: `Move the data or media folder to a new path, e.g. a new disk.
+
+The stack is stopped and the folder is copied to the new path with its
+ownership, modes, symlinks and hard links preserved (so the *arr apps'
+hard links between downloads and media survive). Every file is verified
+by SHA-256 after copying. If the copy is interrupted, run the same command
+again to resume: finished files are skipped and partial ones continued.
+
+Once the copy is complete, FOLDER_FOR_DATA or FOLDER_FOR_MEDIA in .env is
+updated (the previous .env is saved in config-backups/) and the stack is
+deployed again. The old folder is left untouched until you run:
+
+  mediastack migrate-storage data --cleanup`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runMigrateStorage,
+}
+
+func init() {
+	migrateStorageCmd.Flags().Bool("cleanup", false, "Delete the old folder of a completed migration")
+	migrateStorageCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+	migrateStorageCmd.Flags().Bool("no-deploy", false, "Do not deploy the stack after migrating")
+}
+
+func runMigrateStorage(cmd *cobra.Command, args []string) error {
+	cleanup, _ := cmd.Flags().GetBool("cleanup")
+	yes, _ := cmd.Flags().GetBool("yes")
+	noDeploy, _ := cmd.Flags().GetBool("no-deploy")
+
+	var variable, current string
+	switch args[0] {
+	case "data":
+		variable, current = "FOLDER_FOR_DATA", cfg.DataFolder
+	case "media":
+		variable, current = "FOLDER_FOR_MEDIA", cfg.MediaFolder
+	default:
+		return fmt.Errorf("unknown folder %q: use data or media", args[0])
+	}
+
+	if cleanup {
+		return cleanupMigration(variable, current, yes)
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("the new path for %s is required", variable)
+	}
+
+	to, err := filepath.Abs(args[1])
+	if err != nil {
+		return err
+	}
+	from := filepath.Clean(current)
+
+	migrations, err := stack.LoadMigrations(cfg.ConfigDir)
+	if err != nil {
+		return err
+	}
+	prev, recorded := migrations[variable]
+	resuming := recorded && prev.To == to && prev.From == from
+	if recorded && !resuming {
+		// Only one migration per folder is recorded, and it is the only
+		// way --cleanup finds the old folder
+		if !prev.Completed.IsZero() {
+			return fmt.Errorf("the old folder %s of the migration to %s has not been removed yet\nRun 'mediastack migrate-storage %s --cleanup' first", prev.From, prev.To, args[0])
+		}
+		color.Yellow("Abandoning the unfinished migration to %s; remove its partial copy yourself", prev.To)
+	}
+	if err := checkMigrationPaths(from, to, resuming); err != nil {
+		return err
+	}
+
+	size, files, err := stack.DirSize(from)
+	if err != nil {
+		return fmt.Errorf("failed to measure %s: %w", from, err)
+	}
+
+	fmt.Printf("Move %s from %s to %s (%s in %d files)\n", variable, from, to, formatBytes(uint64(size)), files)
+	if resuming {
+		fmt.Println("Resuming an earlier migration")
+	}
+
+	if dryRun {
+		color.Cyan("[dry-run] Would stop the stack, copy and verify %s, update .env and redeploy", from)
+		return nil
+	}
+
+	if !yes {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("migrating stops the stack; use --yes to confirm")
+		}
+		if !confirm("Stop the stack and migrate?") {
+			return fmt.Errorf("migration cancelled")
+		}
+	}
+
+	release, err := acquireStackLock()
+	if err != nil {
+		return err
+	}
+	locked := true
+	defer func() {
+		if locked {
+			release()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if !resuming {
+		migrations[variable] = stack.Migration{Variable: variable, From: from, To: to, Started: time.Now()}
+		if err := stack.SaveMigrations(cfg.ConfigDir, migrations); err != nil {
+			return err
+		}
+	}
+
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+	if err := compose.Stop(ctx); err != nil {
+		return fmt.Errorf("failed to stop the stack: %w", err)
+	}
+
+	color.Cyan("Copying %s to %s...", from, to)
+	stats, err := stack.CopyTree(from, to, size, migrationProgress())
+	if err != nil {
+		// The old folder is untouched, so the stack can run from it again.
+		// The copy may have outlived ctx.
+		fmt.Println()
+		color.Yellow("Copy failed; starting the stack again from %s...", from)
+		startCtx, startCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer startCancel()
+		if serr := compose.Start(startCtx); serr != nil {
+			color.Red("Failed to start the stack: %v", serr)
+		}
+		return fmt.Errorf("%w\nRun the same command again to resume", err)
+	}
+
+	fmt.Printf("  %d files copied, %d already present, %d hard links, %d directories\n",
+		stats.Files, stats.Skipped, stats.Linked, stats.Dirs)
+	if stats.ChownFailed > 0 {
+		color.Yellow("  Warning: Could not set ownership of %d files (run as root to preserve owners)", stats.ChownFailed)
+	}
+
+	if err := updateEnvFolder(variable, to); err != nil {
+		return err
+	}
+	m := migrations[variable]
+	m.Completed = time.Now()
+	migrations[variable] = m
+	if err := stack.SaveMigrations(cfg.ConfigDir, migrations); err != nil {
+		return err
+	}
+	color.Green("%s is now %s", variable, to)
+
+	if !noDeploy {
+		release()
+		locked = false
+
+		if cfg, err = config.Load(cfg.ConfigDir); err != nil {
+			return fmt.Errorf("failed to reload config: %w", err)
+		}
+		if variant != "" {
+			cfg.Variant = variant
+		}
+		if err := runDeploy(deployCmd, nil); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println()
+	color.Yellow("The old folder %s has been left in place.", from)
+	fmt.Printf("Once the stack works from %s, remove it with: mediastack migrate-storage %s --cleanup\n", to, args[0])
+	return nil
+}
+
+// checkMigrationPaths refuses to copy a folder into itself, or into a
+// directory with other content unless an earlier run is being resumed
+func checkMigrationPaths(from, to string, resuming bool) error {
+	if info, err := os.Stat(from); err != nil || !info.IsDir() {
+		return fmt.Errorf("current folder %s does not exist", from)
+	}
+	if to == from {
+		return fmt.Errorf("%s is already the current folder", to)
+	}
+	for _, pair := range [][2]string{{from, to}, {to, from}} {
+		if rel, err := filepath.Rel(pair[0], pair[1]); err == nil && !strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("%s and %s must not be inside one another", from, to)
+		}
+	}
+
+	entries, err := os.ReadDir(to)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 && !resuming {
+		return fmt.Errorf("%s is not empty", to)
+	}
+	return nil
+}
+
+// migrationProgress returns a CopyTree progress callback that redraws one
+// line on a terminal, or prints a line every 30 seconds otherwise
+func migrationProgress() func(stack.CopyStats) {
+	start := time.Now()
+	tty := isTerminal(os.Stdout)
+	var last time.Time
+
+	return func(s stack.CopyStats) {
+		done := s.Bytes >= s.Total
+		if !tty && !done && time.Since(last) < 30*time.Second {
+			return
+		}
+		last = time.Now()
+
+		pct := 100.0
+		if s.Total > 0 {
+			pct = float64(s.Bytes) * 100 / float64(s.Total)
+		}
+		rate := float64(s.Bytes) / time.Since(start).Seconds()
+		line := fmt.Sprintf("  %s / %s (%.1f%%) %s/s", formatBytes(uint64(s.Bytes)), formatBytes(uint64(s.Total)), pct, formatBytes(uint64(rate)))
+
+		if tty {
+			fmt.Printf("\r\033[K%s", line)
+			if done {
+				fmt.Println()
+			}
+			return
+		}
+		fmt.Println(line)
+	}
+}
+
+// updateEnvFolder points variable in .env at path, saving the previous
+// .env in config-backups
+func updateEnvFolder(variable, path string) error {
+	envPath := filepath.Join(cfg.ConfigDir, ".env")
+	saveDir := filepath.Join(cfg.ConfigDir, stack.ConfigBackupDir, time.Now().Format("20060102-150405"))
+	data, err := os.ReadFile(envPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(saveDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(saveDir, ".env"), data, 0600); err != nil {
+		return err
+	}
+	return config.SetEnvValue(envPath, variable, path)
+}
+
+// cleanupMigration deletes the old folder of a completed migration
+func cleanupMigration(variable, current string, yes bool) error {
+	migrations, err := stack.LoadMigrations(cfg.ConfigDir)
+	if err != nil {
+		return err
+	}
+	m, ok := migrations[variable]
+	if !ok {
+		return fmt.Errorf("no migration of %s is recorded", variable)
+	}
+	if m.Completed.IsZero() {
+		return fmt.Errorf("the migration of %s to %s has not completed; run it again to resume", variable, m.To)
+	}
+	if filepath.Clean(current) != m.To {
+		return fmt.Errorf("%s in .env is %s, not %s; refusing to delete %s", variable, current, m.To, m.From)
+	}
+
+	size, _, _ := stack.DirSize(m.From)
+	if dryRun {
+		color.Cyan("[dry-run] Would delete %s (%s)", m.From, formatBytes(uint64(size)))
+		return nil
+	}
+	if !yes {
+		if !isTerminal(os.Stdin) {
+			return fmt.Errorf("use --yes to confirm deleting %s", m.From)
+		}
+		if !confirm(fmt.Sprintf("Delete %s (%s)?", m.From, formatBytes(uint64(size)))) {
+			return fmt.Errorf("cleanup cancelled")
+		}
+	}
+
+	color.Cyan("Deleting %s...", m.From)
+	if err := os.RemoveAll(m.From); err != nil {
+		return err
+	}
+
+	delete(migrations, variable)
+	if err := stack.SaveMigrations(cfg.ConfigDir, migrations); err != nil {
+		return err
+	}
+	color.Green("Removed %s", m.From)
+	return nil
+}
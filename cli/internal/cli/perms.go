@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// permsListLimit is how many paths are listed per service without --verbose
+const permsListLimit = 10
+
+var permsCmd = &cobra.Command{
+	Use:   "perms",
+	Short: "Check and fix ownership and modes in the data and media folders",
+	Long: `Check that the directories the services mount in FOLDER_FOR_DATA and
+FOLDER_FOR_MEDIA belong to PUID:PGID and have the mode bits the apps need:
+
+- FOLDER_FOR_DATA: owner read/write (and search on directories)
+- FOLDER_FOR_MEDIA: group read/write too, setgid on directories, so the
+  download clients and *arr apps can share files
+
+Mode bits are only ever added. Trees a service writes to as another user
+(e.g. traefik as root) are skipped.`,
+}
+
+var permsCheckCmd = &cobra.Command{
+	Use:   "check [service...]",
+	Short: "Report paths with the wrong owner or mode",
+	Long: `Scan the mounted trees concurrently and report every path that is not
+owned by PUID:PGID or lacks required mode bits, grouped by the services
+mounting it. Exits with an error when anything needs fixing.`,
+	RunE: runPermsCheck,
+}
+
+var permsFixCmd = &cobra.Command{
+	Use:   "fix [service...]",
+	Short: "Fix the owner and mode of offending paths",
+	Long: `Scan like 'perms check', then change the owner and mode of the offending
+paths only, and print a summary of the changes and failures. Changing
+ownership usually needs root.`,
+	RunE: runPermsFix,
+}
+
+func init() {
+	permsCmd.AddCommand(permsCheckCmd)
+	permsCmd.AddCommand(permsFixCmd)
+
+	permsCmd.PersistentFlags().Int("parallel", 8, "Number of directories to scan at once")
+	permsCheckCmd.Flags().Bool("json", false, "Output as JSON")
+}
+
+// scanPermissions scans the trees mounted by the given services, or all
+func scanPermissions(cmd *cobra.Command, args []string) (*stack.PermReport, error) {
+	parallel, _ := cmd.Flags().GetInt("parallel")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
+	compose.SetVerbose(verbose)
+
+	services, err := compose.ResolveServices(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := requiredDirectories(ctx, compose)
+	if err != nil {
+		return nil, err
+	}
+
+	return stack.ScanPermissions(dirs, cfg.PUID, cfg.PGID, parallel, services), nil
+}
+
+func runPermsCheck(cmd *cobra.Command, args []string) error {
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+
+	if !jsonOutput {
+		color.Cyan("Checking permissions for %d:%d...", cfg.PUID, cfg.PGID)
+	}
+	report, err := scanPermissions(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		printPermReport(report)
+	}
+
+	if report.Issues > 0 {
+		return fmt.Errorf("%d paths have the wrong owner or mode; run 'mediastack perms fix'", report.Issues)
+	}
+	if !jsonOutput {
+		color.Green("\nAll %d paths are in order", report.Checked)
+	}
+	return nil
+}
+
+func runPermsFix(cmd *cobra.Command, args []string) error {
+	parallel, _ := cmd.Flags().GetInt("parallel")
+
+	color.Cyan("Checking permissions for %d:%d...", cfg.PUID, cfg.PGID)
+	report, err := scanPermissions(cmd, args)
+	if err != nil {
+		return err
+	}
+	printPermReport(report)
+
+	if report.Issues == 0 {
+		color.Green("\nNothing to fix in %d paths", report.Checked)
+		return nil
+	}
+
+	if dryRun {
+		color.Cyan("\n[dry-run] Would fix %d paths", report.Issues)
+		return nil
+	}
+
+	fmt.Println()
+	color.Cyan("Fixing %d paths...", report.Issues)
+	result := stack.FixPermissions(report, parallel)
+
+	color.Green("  Changed the owner of %d and the mode of %d paths", result.Chowned, result.Chmodded)
+	if len(result.Failures) == 0 {
+		return nil
+	}
+
+	color.Red("  Failed to fix %d paths:", len(result.Failures))
+	denied := false
+	for i, f := range result.Failures {
+		denied = denied || f.Denied
+		if i < permsListLimit || verbose {
+			fmt.Printf("    %s: %s\n", f.Path, f.Error)
+		}
+	}
+	if len(result.Failures) > permsListLimit && !verbose {
+		fmt.Printf("    ... and %d more (use --verbose to list all)\n", len(result.Failures)-permsListLimit)
+	}
+	if denied && os.Geteuid() != 0 {
+		color.Yellow("  Changing ownership needs root; try again with sudo")
+	}
+	return errors.New("some paths could not be fixed")
+}
+
+// printPermReport prints a table of the checked trees and the offending
+// paths of each
+func printPermReport(report *stack.PermReport) {
+	fmt.Println()
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Services", "Path", "Checked", "Wrong Owner", "Wrong Mode"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	for _, g := range report.Groups {
+		if g.Skipped != "" {
+			continue
+		}
+		var owner, mode int
+		for _, issue := range g.Issues {
+			if issue.WrongOwner {
+				owner++
+			}
+			if issue.WrongMode {
+				mode++
+			}
+		}
+		table.Append([]string{
+			permServices(g.Services),
+			g.Source,
+			fmt.Sprintf("%d", g.Checked),
+			permCount(owner),
+			permCount(mode),
+		})
+	}
+	table.Render()
+
+	for _, g := range report.Groups {
+		if g.Skipped != "" {
+			if verbose {
+				fmt.Printf("\nSkipped %s: %s\n", g.Source, g.Skipped)
+			}
+			continue
+		}
+
+		if len(g.Errors) > 0 {
+			color.Yellow("\nWarning: %d paths under %s could not be read", len(g.Errors), g.Source)
+			if verbose {
+				for _, e := range g.Errors {
+					fmt.Printf("    %s\n", e)
+				}
+			}
+		}
+
+		if len(g.Issues) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s (%s)\n", permServices(g.Services), g.Source)
+		for i, issue := range g.Issues {
+			if i == permsListLimit && !verbose {
+				fmt.Printf("  ... and %d more (use --verbose to list all)\n", len(g.Issues)-i)
+				break
+			}
+			fmt.Printf("  %s\n", formatPermIssue(issue, report.UID, report.GID))
+		}
+	}
+}
+
+// formatPermIssue describes what is wrong with a path
+func formatPermIssue(issue stack.PermIssue, uid, gid int) string {
+	var problems []string
+	if issue.WrongOwner {
+		problems = append(problems, fmt.Sprintf("owner %d:%d, not %d:%d", issue.UID, issue.GID, uid, gid))
+	}
+	if issue.WrongMode {
+		problems = append(problems, fmt.Sprintf("mode %04o, needs %04o", issue.Mode, issue.WantMode))
+	}
+	path := issue.Path
+	if issue.Dir {
+		path += "/"
+	}
+	return fmt.Sprintf("%s  %s", path, strings.Join(problems, ", "))
+}
+
+// permServices shortens a long list of services sharing a mount
+func permServices(services []string) string {
+	const shown = 3
+	if len(services) <= shown {
+		return strings.Join(services, ", ")
+	}
+	return fmt.Sprintf("%s +%d", strings.Join(services[:shown], ", "), len(services)-shown)
+}
+
+func permCount(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return color.RedString("%d", n)
+}
@@ -0,0 +1,269 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/jxmullins/mediastack/internal/stack"
+	"github.com/olekukonko/tablewriter"
+)
+
+// ServiceAction is what a deploy would do to a service
+type ServiceAction string
+
+const (
+	ActionCreate    ServiceAction = "create"
+	ActionRecreate  ServiceAction = "recreate"
+	ActionRestart   ServiceAction = "restart"
+	ActionStart     ServiceAction = "start"
+	ActionRemove    ServiceAction = "remove"
+	ActionUnchanged ServiceAction = "unchanged"
+)
+
+// actionOrder ranks actions for display, most disruptive first
+var actionOrder = map[ServiceAction]int{
+	ActionRemove:    0,
+	ActionRecreate:  1,
+	ActionCreate:    2,
+	ActionRestart:   3,
+	ActionStart:     4,
+	ActionUnchanged: 5,
+}
+
+// ServicePlan is the planned action for one service
+type ServicePlan struct {
+	Service string        `json:"service"`
+	Action  ServiceAction `json:"action"`
+	Reasons []string      `json:"reasons,omitempty"`
+}
+
+// DeployPlan describes everything a deploy would change
+type DeployPlan struct {
+	Directories []string                 `json:"directories"`
+	ConfigFiles []stack.ConfigFileChange `json:"config_files"`
+	Services    []ServicePlan            `json:"services"`
+}
+
+// computeDeployPlan works out what a deploy would do without modifying anything
+func computeDeployPlan(ctx context.Context, compose *docker.Compose, client *docker.Client, noDirs, noFiles bool, keepLocal []string) (*DeployPlan, error) {
+	plan := &DeployPlan{}
+
+	if !noDirs {
+		dirs, err := requiredDirectories(ctx, compose)
+		if err != nil {
+			return nil, err
+		}
+		plan.Directories = stack.VerifyDirectories(dirs)
+	}
+
+	if !noFiles {
+		files, err := stack.PlanConfigFiles(cfg.ConfigDir, cfg.DataFolder, cfg.Env, keepLocal)
+		if err != nil {
+			return nil, err
+		}
+		plan.ConfigFiles = files
+	}
+
+	services, err := planServices(ctx, compose, client, plan.ConfigFiles)
+	if err != nil {
+		return nil, err
+	}
+	plan.Services = services
+
+	return plan, nil
+}
+
+// planServices compares the rendered compose model with the project's
+// containers. files are the pending config file changes; services reading
+// a changed file are restarted.
+func planServices(ctx context.Context, compose *docker.Compose, client *docker.Client, files []stack.ConfigFileChange) ([]ServicePlan, error) {
+	changedFiles := make(map[string][]string)
+	for _, f := range files {
+		if f.Status == stack.ConfigFileNew || f.Status == stack.ConfigFileChanged {
+			changedFiles[f.Service] = append(changedFiles[f.Service], f.Source)
+		}
+	}
+
+	project, err := compose.Model(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render compose model: %w", err)
+	}
+
+	hashes, err := compose.ConfigHashes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute config hashes: %w", err)
+	}
+
+	containers, err := client.ListContainers(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	byService := make(map[string]docker.ContainerInfo)
+	for _, c := range containers {
+		byService[c.Service] = c
+	}
+
+	var plans []ServicePlan
+	imageIDs := make(map[string]string)
+	for _, name := range project.ServiceNames() {
+		svc := project.Services[name]
+		sp := ServicePlan{Service: name, Action: ActionUnchanged}
+
+		cont, exists := byService[name]
+		if !exists {
+			sp.Action = ActionCreate
+			plans = append(plans, sp)
+			continue
+		}
+
+		if hashes[name] != "" && cont.ConfigHash != hashes[name] {
+			sp.Action = ActionRecreate
+			sp.Reasons = append(sp.Reasons, "configuration changed")
+		}
+
+		if svc.Image != "" {
+			id, ok := imageIDs[svc.Image]
+			if !ok {
+				id, err = client.ImageID(ctx, svc.Image)
+				if err != nil {
+					return nil, fmt.Errorf("failed to inspect image %s: %w", svc.Image, err)
+				}
+				imageIDs[svc.Image] = id
+			}
+			switch {
+			case id == "":
+				sp.Action = ActionRecreate
+				sp.Reasons = append(sp.Reasons, "image not pulled")
+			case id != cont.ImageID:
+				sp.Action = ActionRecreate
+				sp.Reasons = append(sp.Reasons, "image updated")
+			}
+		}
+
+		if files := changedFiles[name]; len(files) > 0 {
+			if sp.Action == ActionUnchanged {
+				sp.Action = ActionRestart
+			}
+			sp.Reasons = append(sp.Reasons, "config file changed: "+strings.Join(files, ", "))
+		}
+
+		if cont.State != "running" && sp.Action == ActionUnchanged {
+			sp.Action = ActionStart
+			sp.Reasons = append(sp.Reasons, "not running ("+cont.State+")")
+		}
+
+		plans = append(plans, sp)
+	}
+
+	// Containers for services no longer in the model are removed as orphans
+	for _, c := range containers {
+		if _, ok := project.Services[c.Service]; !ok {
+			plans = append(plans, ServicePlan{
+				Service: c.Service,
+				Action:  ActionRemove,
+				Reasons: []string{"no longer defined"},
+			})
+		}
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		a, b := plans[i], plans[j]
+		if actionOrder[a.Action] != actionOrder[b.Action] {
+			return actionOrder[a.Action] < actionOrder[b.Action]
+		}
+		return a.Service < b.Service
+	})
+
+	return plans, nil
+}
+
+// changedServices returns every service the plan does not leave unchanged
+func changedServices(plans []ServicePlan) []string {
+	var services []string
+	for _, sp := range plans {
+		if sp.Action != ActionUnchanged {
+			services = append(services, sp.Service)
+		}
+	}
+	return services
+}
+
+// servicesToStop returns the running services that must be stopped before
+// compose up so they pick up their changes
+func servicesToStop(plans []ServicePlan) []string {
+	var services []string
+	for _, sp := range plans {
+		if sp.Action == ActionRecreate || sp.Action == ActionRestart {
+			services = append(services, sp.Service)
+		}
+	}
+	return services
+}
+
+// printDeployPlan renders a deploy plan for review
+func printDeployPlan(plan *DeployPlan) {
+	color.Cyan("Deploy plan for %s (%s)", cfg.ProjectName, cfg.Variant)
+
+	fmt.Printf("\nDirectories to create: %d\n", len(plan.Directories))
+	for _, d := range plan.Directories {
+		color.Green("  + %s", d)
+	}
+
+	fmt.Println("\nConfiguration files:")
+	for _, f := range plan.ConfigFiles {
+		switch f.Status {
+		case stack.ConfigFileNew:
+			color.Green("  + %s -> %s (new)", f.Source, f.Destination)
+		case stack.ConfigFileChanged:
+			color.Yellow("  ~ %s -> %s (changed)", f.Source, f.Destination)
+			stack.PrintDiff(f.Diff, "      ")
+		case stack.ConfigFileKeepLocal:
+			color.Cyan("  = %s -> %s (differs, kept local)", f.Source, f.Destination)
+			stack.PrintDiff(f.Diff, "      ")
+		case stack.ConfigFileMissingSource:
+			color.Red("  ! %s (source missing, skipped)", f.Source)
+		default:
+			fmt.Printf("    %s (unchanged)\n", f.Source)
+		}
+	}
+
+	fmt.Println("\nServices:")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Service", "Action", "Reason"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	counts := make(map[ServiceAction]int)
+	for _, sp := range plan.Services {
+		counts[sp.Action]++
+		table.Append([]string{
+			sp.Service,
+			getActionColor(sp.Action)("%s", sp.Action),
+			strings.Join(sp.Reasons, "; "),
+		})
+	}
+	table.Render()
+
+	fmt.Printf("\nCreate: %d | Recreate: %d | Restart: %d | Start: %d | Remove: %d | Unchanged: %d\n",
+		counts[ActionCreate], counts[ActionRecreate], counts[ActionRestart],
+		counts[ActionStart], counts[ActionRemove], counts[ActionUnchanged])
+}
+
+func getActionColor(action ServiceAction) func(format string, a ...interface{}) string {
+	switch action {
+	case ActionRemove:
+		return color.RedString
+	case ActionRecreate, ActionRestart:
+		return color.YellowString
+	case ActionCreate, ActionStart:
+		return color.GreenString
+	default:
+		return fmt.Sprintf
+	}
+}
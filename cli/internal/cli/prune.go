@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/olekukonko/tablewriter"
+)
+
+// pruneResources removes stopped containers, unused volumes and unused
+// networks. Unless global is set only resources labelled with the project
+// are touched.
+func pruneResources(ctx context.Context, client *docker.Client, global bool, indent string) {
+	if global {
+		color.Yellow("%sPruning host-wide (--global): resources of other projects may be removed", indent)
+	}
+
+	prunes := []func(context.Context, bool) (docker.PruneReport, error){
+		client.PruneContainers,
+		client.PruneVolumes,
+		client.PruneNetworks,
+	}
+
+	for _, prune := range prunes {
+		report, err := prune(ctx, global)
+		if err != nil {
+			color.Yellow("%sWarning: Failed to prune %ss: %v", indent, report.Kind, err)
+			continue
+		}
+		if verbose || len(report.Deleted) > 0 {
+			fmt.Printf("%sRemoved %d %s(s), reclaimed %s\n", indent, len(report.Deleted), report.Kind, formatBytes(report.SpaceReclaimed))
+		}
+	}
+}
+
+// pruneImages removes the unused images of the project's services, or every
+// unused image when global is set
+func pruneImages(ctx context.Context, client *docker.Client, images []string, global bool, indent string) {
+	report, err := client.PruneImages(ctx, images, global)
+	if err != nil {
+		color.Yellow("%sWarning: Failed to prune images: %v", indent, err)
+	}
+	if len(report.Deleted) > 0 || err == nil {
+		fmt.Printf("%sRemoved %d image(s), reclaimed %s\n", indent, len(report.Deleted), formatBytes(report.SpaceReclaimed))
+	}
+}
+
+// showPruneCandidates prints what pruneResources, and pruneImages for the
+// service images in images unless they are nil, would remove
+func showPruneCandidates(ctx context.Context, client *docker.Client, global bool, images []string) error {
+	candidates, err := client.PruneCandidates(ctx, global, images)
+	if err != nil {
+		return err
+	}
+
+	scope := fmt.Sprintf("project %s", cfg.ProjectName)
+	if global {
+		scope = "all projects (--global)"
+	}
+
+	if len(candidates) == 0 {
+		color.Cyan("[dry-run] Nothing to prune for %s", scope)
+		return nil
+	}
+
+	color.Cyan("[dry-run] Would prune for %s:", scope)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Type", "Name", "ID", "Size"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	var total int64
+	for _, c := range candidates {
+		size := "-"
+		if c.Size >= 0 {
+			size = formatBytes(uint64(c.Size))
+			total += c.Size
+		}
+		table.Append([]string{c.Kind, c.Name, c.ID, size})
+	}
+
+	table.Render()
+	fmt.Printf("Total: %d resources, %s\n", len(candidates), formatBytes(uint64(total)))
+	return nil
+}
@@ -23,7 +23,7 @@ func init() {
 	pullCmd.Flags().Int("parallel", 3, "Number of parallel image pulls")
 }
 
-func runPull(cmd *cobra.Command, args []string) error {
+func runPull(cmd *cobra.Command, args []string) (retErr error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
 
@@ -32,12 +32,31 @@ func runPull(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	release, err := acquireStackLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	history := startHistory(ctx, cmd, "pull", args)
+	defer func() { history.finish(retErr) }()
+
 	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
 	compose.SetVerbose(verbose)
 
-	if len(args) > 0 {
+	services, err := compose.ResolveServices(ctx, args)
+	if err != nil {
+		return err
+	}
+	history.setServices(services)
+	if len(services) == 0 {
+		history.setAllServices()
+	}
+	history.trackPulledImages(ctx, compose)
+
+	if len(services) > 0 {
 		// Pull specific services
-		for _, service := range args {
+		for _, service := range services {
 			color.Cyan("Pulling image for: %s", service)
 			if err := compose.PullService(ctx, service); err != nil {
 				return fmt.Errorf("failed to pull %s: %w", service, err)
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/olekukonko/tablewriter"
+)
+
+// readinessLogLines is the number of log lines shown for services that
+// never became ready
+const readinessLogLines = 10
+
+// verifyReadiness waits for every enabled service to become ready and
+// prints a readiness table. It returns an error if a service not listed in
+// optional never became ready.
+func verifyReadiness(ctx context.Context, compose *docker.Compose, client *docker.Client, timeout time.Duration, optional []string) error {
+	project, err := compose.Model(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read compose model: %w", err)
+	}
+
+	optionalSet := make(map[string]bool, len(optional))
+	for _, s := range optional {
+		optionalSet[s] = true
+	}
+
+	// Container addresses cannot be dialled when the daemon is remote or
+	// runs in a VM; readiness then rests on Docker's state and health checks
+	_, skipProbes := client.ProbesReachable(ctx)
+	if skipProbes != "" {
+		color.Yellow("  Skipping HTTP/TCP probes, %s; relying on container state and health checks", skipProbes)
+	}
+	color.Cyan("  Waiting up to %s for services to become ready...", timeout)
+	results := client.VerifyReadiness(ctx, project, project.ServiceNames(), docker.ReadinessOptions{
+		Timeout:      timeout,
+		Interval:     3 * time.Second,
+		ProbeTimeout: 5 * time.Second,
+		LogLines:     readinessLogLines,
+		SkipProbes:   skipProbes,
+	})
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Service", "Ready", "State", "Health", "Probes", "Time", "Reason"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+
+	var notReady, failedRequired []docker.ServiceReadiness
+	for _, r := range results {
+		ready := color.GreenString("yes")
+		if !r.Ready {
+			notReady = append(notReady, r)
+			if optionalSet[r.Service] {
+				ready = color.YellowString("no (optional)")
+			} else {
+				ready = color.RedString("no")
+				failedRequired = append(failedRequired, r)
+			}
+		}
+
+		health := r.Health
+		if health == "" {
+			health = "-"
+		}
+		table.Append([]string{
+			r.Service,
+			ready,
+			r.State,
+			health,
+			strings.Join(r.Probes, ", "),
+			r.Elapsed.Round(time.Second).String(),
+			truncateString(r.Reason, 60),
+		})
+	}
+	table.Render()
+
+	for _, r := range notReady {
+		if len(r.Logs) == 0 {
+			continue
+		}
+		color.Yellow("\n  Last log lines from %s:", r.Service)
+		for _, line := range r.Logs {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+
+	if len(failedRequired) > 0 {
+		names := make([]string, len(failedRequired))
+		for i, r := range failedRequired {
+			names[i] = r.Service
+		}
+		return fmt.Errorf("%d service(s) did not become ready: %s", len(names), strings.Join(names, ", "))
+	}
+
+	color.Green("  %d/%d services ready", len(results)-len(notReady), len(results))
+	return nil
+}
@@ -25,7 +25,7 @@ func init() {
 	restartCmd.Flags().Bool("force", false, "Force recreate containers")
 }
 
-func runRestart(cmd *cobra.Command, args []string) error {
+func runRestart(cmd *cobra.Command, args []string) (retErr error) {
 	pullFirst, _ := cmd.Flags().GetBool("pull")
 	force, _ := cmd.Flags().GetBool("force")
 
@@ -40,20 +40,39 @@ func runRestart(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	release, err := acquireStackLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	history := startHistory(ctx, cmd, "restart", args)
+	defer func() { history.finish(retErr) }()
+
 	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
 	compose.SetVerbose(verbose)
 
+	services, err := compose.ResolveServices(ctx, args)
+	if err != nil {
+		return err
+	}
+	history.setServices(services)
+	if len(services) == 0 {
+		history.setAllServices()
+	}
+
 	// Pull images if requested
 	if pullFirst {
+		history.trackPulledImages(ctx, compose)
 		color.Cyan("Pulling images...")
 		if err := compose.Pull(ctx); err != nil {
 			return fmt.Errorf("failed to pull images: %w", err)
 		}
 	}
 
-	if len(args) > 0 {
+	if len(services) > 0 {
 		// Restart specific services
-		for _, service := range args {
+		for _, service := range services {
 			color.Cyan("Restarting service: %s", service)
 			if err := compose.RestartService(ctx, service); err != nil {
 				return fmt.Errorf("failed to restart %s: %w", service, err)
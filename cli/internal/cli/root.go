@@ -101,6 +101,18 @@ func init() {
 	rootCmd.AddCommand(logsCmd)
 	rootCmd.AddCommand(pullCmd)
 	rootCmd.AddCommand(apikeysCmd)
+	rootCmd.AddCommand(eventsCmd)
+	rootCmd.AddCommand(topCmd)
+	rootCmd.AddCommand(cpCmd)
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(duCmd)
+	rootCmd.AddCommand(unlockCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(dbCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(migrateStorageCmd)
+	rootCmd.AddCommand(permsCmd)
 }
 
 // Execute runs the root command
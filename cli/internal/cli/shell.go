@@ -1,8 +1,11 @@
 package cli
 
 import (
+	"errors"
+
 	"github.com/jxmullins/mediastack/internal/shell"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var shellCmd = &cobra.Command{
@@ -30,5 +33,24 @@ func init() {
 
 func runShell(cmd *cobra.Command, args []string) error {
 	sh := shell.New(cfg)
+	sh.SetDeploy(shellDeploy)
 	return sh.Run(Version)
 }
+
+// shellDeploy runs the shell's /deploy command through runDeploy. The
+// arguments are parsed on a fresh deploy command, so flags from an earlier
+// /deploy do not carry over.
+func shellDeploy(args []string) error {
+	cmd := newDeployCmd()
+	if err := cmd.ParseFlags(args); errors.Is(err, pflag.ErrHelp) {
+		return cmd.Help()
+	} else if err != nil {
+		return err
+	}
+
+	// The shell owns stdin, so deploy must not prompt
+	interactive = false
+	defer func() { interactive = true }()
+
+	return runDeploy(cmd, cmd.Flags().Args())
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/jxmullins/mediastack/internal/stack"
 	"github.com/spf13/cobra"
 )
 
@@ -16,7 +17,9 @@ var stopCmd = &cobra.Command{
 	Long: `Stop all or specific MediaStack containers.
 
 If no service names are provided, all services will be stopped.
-Use --prune to also remove unused containers, volumes, and networks.`,
+Use --prune to also remove this stack's stopped containers, unused
+volumes, and unused networks. Add --global to prune resources of every
+project on the host.`,
 	RunE: runStop,
 }
 
@@ -24,12 +27,15 @@ func init() {
 	stopCmd.Flags().Bool("remove-orphans", true, "Remove orphaned containers")
 	stopCmd.Flags().BoolP("volumes", "v", false, "Also remove volumes")
 	stopCmd.Flags().Bool("prune", false, "Prune unused resources after stop")
+	stopCmd.Flags().Bool("global", false, "Prune resources of all projects on the host, not just this stack")
+	addHookFlags(stopCmd)
 }
 
-func runStop(cmd *cobra.Command, args []string) error {
+func runStop(cmd *cobra.Command, args []string) (retErr error) {
 	removeOrphans, _ := cmd.Flags().GetBool("remove-orphans")
 	removeVolumes, _ := cmd.Flags().GetBool("volumes")
 	prune, _ := cmd.Flags().GetBool("prune")
+	global, _ := cmd.Flags().GetBool("global")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
@@ -37,17 +43,49 @@ func runStop(cmd *cobra.Command, args []string) error {
 	if dryRun {
 		color.Cyan("[dry-run] Would stop MediaStack containers")
 		if prune {
-			color.Cyan("[dry-run] Would prune unused resources")
+			client, err := docker.NewClient(cfg.ProjectName)
+			if err != nil {
+				return fmt.Errorf("failed to create Docker client: %w", err)
+			}
+			defer client.Close()
+			return showPruneCandidates(ctx, client, global, nil)
 		}
 		return nil
 	}
 
+	release, err := acquireStackLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	history := startHistory(ctx, cmd, "stop", args)
+	defer func() { history.finish(retErr) }()
+
 	compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
 	compose.SetVerbose(verbose)
 
+	services, err := compose.ResolveServices(ctx, args)
+	if err != nil {
+		return err
+	}
+	history.setServices(services)
+	if len(services) == 0 {
+		history.setAllServices()
+	}
+
+	hooks, closeLog := newHookSession(cmd, "stop")
+	defer closeLog()
+	hooks.SetServices(services)
+	if err := hooks.Pre(ctx, stack.HookPreStop); err != nil {
+		finishHooks(hooks, stack.HookPostStop, err)
+		return err
+	}
+	defer func() { finishHooks(hooks, stack.HookPostStop, retErr) }()
+
 	// Stop specific services or all
-	if len(args) > 0 {
-		for _, service := range args {
+	if len(services) > 0 {
+		for _, service := range services {
 			color.Cyan("Stopping service: %s", service)
 			if err := compose.StopService(ctx, service); err != nil {
 				return fmt.Errorf("failed to stop %s: %w", service, err)
@@ -71,18 +109,7 @@ func runStop(cmd *cobra.Command, args []string) error {
 		}
 		defer client.Close()
 
-		if err := client.PruneContainers(ctx); err != nil {
-			color.Yellow("Warning: Failed to prune containers: %v", err)
-		}
-
-		if err := client.PruneVolumes(ctx); err != nil {
-			color.Yellow("Warning: Failed to prune volumes: %v", err)
-		}
-
-		if err := client.PruneNetworks(ctx); err != nil {
-			color.Yellow("Warning: Failed to prune networks: %v", err)
-		}
-
+		pruneResources(ctx, client, global, "")
 		color.Green("Pruning complete")
 	}
 
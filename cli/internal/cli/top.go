@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jxmullins/mediastack/internal/docker"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show container resource usage",
+	Long: `Display live CPU, memory, network and block I/O usage for all
+running MediaStack containers.
+
+Use --sort to order by cpu, mem, net, io or name, and --no-stream to
+print a single sample and exit.`,
+	RunE: runTop,
+}
+
+func init() {
+	topCmd.Flags().String("sort", "cpu", "Sort by column: cpu, mem, net, io, name")
+	topCmd.Flags().Bool("no-stream", false, "Print a single sample and exit")
+	topCmd.Flags().Bool("json", false, "Output as JSON")
+	topCmd.Flags().Duration("interval", 2*time.Second, "Refresh interval")
+}
+
+func runTop(cmd *cobra.Command, args []string) error {
+	sortBy, _ := cmd.Flags().GetString("sort")
+	noStream, _ := cmd.Flags().GetBool("no-stream")
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	interval, _ := cmd.Flags().GetDuration("interval")
+
+	less, err := statsSorter(sortBy)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, err := docker.NewClient(cfg.ProjectName)
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer client.Close()
+
+	if noStream {
+		return showTop(ctx, client.ProjectStats, less, jsonOutput)
+	}
+
+	// Keep a stats stream open per container so each refresh reads the
+	// latest samples instead of waiting for new ones
+	monitor := client.NewStatsMonitor()
+	defer monitor.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if !jsonOutput {
+			// Clear screen
+			fmt.Print("\033[H\033[2J")
+		}
+
+		if err := showTop(ctx, monitor.Sample, less, jsonOutput); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			color.Red("Error: %v", err)
+		}
+
+		if !jsonOutput {
+			fmt.Printf("\nPress Ctrl+C to exit (updating every %s, sorted by %s)\n", interval, sortBy)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			continue
+		}
+	}
+}
+
+func showTop(ctx context.Context, sample func(context.Context) ([]docker.ContainerStats, error), less func(a, b docker.ContainerStats) bool, jsonOutput bool) error {
+	stats, err := sample(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get container stats: %w", err)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return less(stats[i], stats[j])
+	})
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(stats) == 0 {
+		color.Yellow("No running containers found for project: %s", cfg.ProjectName)
+		return nil
+	}
+
+	return outputStatsTable(stats)
+}
+
+func outputStatsTable(stats []docker.ContainerStats) error {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "CPU %", "Mem Usage / Limit", "Mem %", "Net I/O", "Block I/O", "PIDs"})
+	table.SetAutoWrapText(false)
+	table.SetBorder(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetTablePadding("  ")
+	table.SetNoWhiteSpace(true)
+
+	var totalCPU float64
+	var totalMem uint64
+	var failed []docker.ContainerStats
+
+	for _, s := range stats {
+		if s.Error != "" {
+			failed = append(failed, s)
+			table.Append([]string{s.Name, color.RedString("error"), "-", "-", "-", "-", "-"})
+			continue
+		}
+
+		totalCPU += s.CPUPercent
+		totalMem += s.MemUsage
+
+		table.Append([]string{
+			s.Name,
+			getUsageColor(s.CPUPercent)("%.2f%%", s.CPUPercent),
+			fmt.Sprintf("%s / %s", formatBytes(s.MemUsage), formatBytes(s.MemLimit)),
+			getUsageColor(s.MemPercent)("%.2f%%", s.MemPercent),
+			fmt.Sprintf("%s / %s", formatBytes(s.NetRx), formatBytes(s.NetTx)),
+			fmt.Sprintf("%s / %s", formatBytes(s.BlockRead), formatBytes(s.BlockWrite)),
+			fmt.Sprintf("%d", s.PIDs),
+		})
+	}
+
+	fmt.Printf("\nMediaStack Resource Usage (%s)\n\n", cfg.ProjectName)
+	table.Render()
+
+	fmt.Printf("\nContainers: %d | CPU: %.2f%% | Memory: %s\n", len(stats), totalCPU, formatBytes(totalMem))
+
+	if len(failed) > 0 {
+		fmt.Println()
+		for _, s := range failed {
+			color.Yellow("%s: %s", s.Name, s.Error)
+		}
+	}
+	return nil
+}
+
+// statsSorter returns the ordering for the given sort column
+func statsSorter(column string) (func(a, b docker.ContainerStats) bool, error) {
+	switch column {
+	case "cpu":
+		return func(a, b docker.ContainerStats) bool { return a.CPUPercent > b.CPUPercent }, nil
+	case "mem", "memory":
+		return func(a, b docker.ContainerStats) bool { return a.MemUsage > b.MemUsage }, nil
+	case "net":
+		return func(a, b docker.ContainerStats) bool { return a.NetRx+a.NetTx > b.NetRx+b.NetTx }, nil
+	case "io", "block":
+		return func(a, b docker.ContainerStats) bool { return a.BlockRead+a.BlockWrite > b.BlockRead+b.BlockWrite }, nil
+	case "name":
+		return func(a, b docker.ContainerStats) bool { return a.Name < b.Name }, nil
+	default:
+		return nil, fmt.Errorf("invalid sort column: %s (use cpu, mem, net, io or name)", column)
+	}
+}
+
+func getUsageColor(percent float64) func(format string, a ...interface{}) string {
+	switch {
+	case percent >= 90:
+		return color.RedString
+	case percent >= 50:
+		return color.YellowString
+	default:
+		return fmt.Sprintf
+	}
+}
+
+// formatBytes renders a byte count using binary units
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
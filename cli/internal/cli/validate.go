@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/fatih/color"
@@ -21,8 +22,9 @@ Checks performed:
 - Required environment variables are set
 - Docker daemon is accessible
 - Docker Compose configuration is valid
-- Required config files exist
-- Directory structure can be verified`,
+- Required config files exist and their ${VAR} references resolve
+- Every directory the services bind-mount exists and is a directory
+- Download clients and *arr apps can hard-link downloads into media/`,
 	RunE: runValidate,
 }
 
@@ -75,6 +77,7 @@ func runValidate(cmd *cobra.Command, args []string) error {
 	}
 
 	// 4. Validate compose file
+	var dirs *stack.Directories
 	if cfg != nil {
 		fmt.Println("\nValidating compose configuration...")
 		compose := docker.NewCompose(cfg.ProjectName, cfg.ConfigDir, cfg.ComposeFile())
@@ -95,6 +98,11 @@ func runValidate(cmd *cobra.Command, args []string) error {
 			if err == nil {
 				color.Green("  Found %d services", len(services))
 			}
+
+			if dirs, err = requiredDirectories(ctx, compose); err != nil {
+				color.Yellow("  Warning: %v", err)
+				hasWarnings = true
+			}
 		}
 	}
 
@@ -110,12 +118,24 @@ func runValidate(cmd *cobra.Command, args []string) error {
 		} else {
 			color.Green("  All configuration files present")
 		}
+
+		rendered := true
+		for _, cf := range stack.ConfigFiles {
+			if _, err := stack.RenderConfigFile(cfg.ConfigDir, cf, cfg.Env); err != nil && !os.IsNotExist(err) {
+				color.Red("  Error: %v", err)
+				hasErrors = true
+				rendered = false
+			}
+		}
+		if rendered {
+			color.Green("  All configuration file variables resolve")
+		}
 	}
 
 	// 6. Check required directories
-	if cfg != nil {
+	if dirs != nil {
 		fmt.Println("\nChecking directory structure...")
-		missing := stack.VerifyDirectories(cfg.DataFolder, cfg.MediaFolder)
+		missing := stack.VerifyDirectories(dirs)
 		if len(missing) > 0 {
 			color.Yellow("  Warning: %d directories need to be created", len(missing))
 			if verbose {
@@ -127,9 +147,45 @@ func runValidate(cmd *cobra.Command, args []string) error {
 		} else {
 			color.Green("  All directories exist")
 		}
+
+		problems := dirs.CheckMounts()
+		for _, p := range problems {
+			if p.Missing {
+				color.Yellow("  Warning: %s mounts %s, which does not exist (docker would create it owned by root)", p.Service, p.Source)
+				hasWarnings = true
+			} else {
+				color.Red("  Error: %s mounts %s, which %s", p.Service, p.Source, p.Problem)
+				hasErrors = true
+			}
+		}
+		if len(problems) == 0 {
+			color.Green("  All %d bind mounts are usable", len(dirs.Mounts))
+		}
 	}
 
-	// 7. Check environment variables
+	// 7. Check that downloads can be hard-linked into media/
+	if cfg != nil {
+		fmt.Println("\nChecking hard links between downloads and media...")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		mounts, source := hardlinkMounts(ctx, dirs)
+		if mounts == nil {
+			color.Yellow("  Warning: Skipped: no containers or compose model to read mounts from")
+			hasWarnings = true
+		} else {
+			warnings := stack.CheckHardlinks(cfg.MediaFolder, mounts)
+			for _, w := range warnings {
+				color.Yellow("  Warning: %s", w.Message)
+				hasWarnings = true
+			}
+			if len(warnings) == 0 {
+				color.Green("  Download clients and *arr apps can hard-link (mounts from %s)", source)
+			}
+		}
+	}
+
+	// 8. Check environment variables
 	if cfg != nil {
 		fmt.Println("\nChecking environment variables...")
 		requiredVars := []string{
@@ -185,3 +241,29 @@ func runValidate(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// hardlinkMounts returns the bind mounts of the project's containers, or of
+// the compose model when no containers exist yet, and which it used
+func hardlinkMounts(ctx context.Context, dirs *stack.Directories) ([]stack.Mount, string) {
+	if client, err := docker.NewClient(cfg.ProjectName); err == nil {
+		defer client.Close()
+		if containers, err := client.ContainerMounts(ctx); err == nil && len(containers) > 0 {
+			mounts := []stack.Mount{}
+			for _, m := range containers {
+				if m.Type == "bind" {
+					mounts = append(mounts, stack.Mount{
+						Service:  m.Service,
+						Source:   m.Source,
+						Target:   m.Destination,
+						ReadOnly: !m.RW,
+					})
+				}
+			}
+			return mounts, "the containers"
+		}
+	}
+	if dirs != nil {
+		return dirs.Mounts, "the compose model"
+	}
+	return nil, ""
+}
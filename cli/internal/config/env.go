@@ -123,6 +123,66 @@ func expandVariables(s string, env map[string]string) string {
 	return result
 }
 
+// SetEnvValue rewrites the value of key in the .env file at path, keeping
+// the rest of the line (inline comment and alignment) and the file's mode
+func SetEnvValue(path, key, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read .env file: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.ContainsAny(value, " \t#") {
+		value = `"` + value + `"`
+	}
+
+	lines := strings.Split(string(data), "\n")
+	found := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		k, rest, ok := strings.Cut(trimmed, "=")
+		if !ok || strings.TrimSpace(k) != key {
+			continue
+		}
+
+		// Keep whatever follows the old value: padding and an inline comment
+		old := rest
+		if q := rest[:min(1, len(rest))]; q == `"` || q == "'" {
+			if end := strings.Index(rest[1:], q); end != -1 {
+				old = rest[:end+2]
+			}
+		} else if idx := strings.IndexAny(rest, " \t"); idx != -1 {
+			old = rest[:idx]
+		}
+		suffix := rest[len(old):]
+		if pad := len(old) - len(value); pad > 0 && strings.HasPrefix(strings.TrimLeft(suffix, " \t"), "#") {
+			suffix = strings.Repeat(" ", pad) + suffix
+		}
+
+		lines[i] = key + "=" + value + suffix
+		found = true
+	}
+	if !found {
+		if n := len(lines); lines[n-1] == "" {
+			lines = append(lines[:n-1], key+"="+value, "")
+		} else {
+			lines = append(lines, key+"="+value)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 // ExportToEnvironment exports all env vars to the current process
 func ExportToEnvironment(env map[string]string) {
 	for key, value := range env {
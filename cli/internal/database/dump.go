@@ -0,0 +1,231 @@
+package database
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dumpMetadataFile is the first entry of a dump archive
+const dumpMetadataFile = "metadata.json"
+
+// DumpMetadata describes the contents of a dump archive
+type DumpMetadata struct {
+	Created       time.Time `json:"created"`
+	Project       string    `json:"project"`
+	Image         string    `json:"image"`
+	ImageID       string    `json:"image_id"`
+	ServerVersion string    `json:"server_version"`
+	Databases     []string  `json:"databases"`
+	Format        string    `json:"format"`
+}
+
+// MajorVersion returns the major version of the server that made the dump
+func (m *DumpMetadata) MajorVersion() string {
+	return MajorVersion(m.ServerVersion)
+}
+
+// MajorVersion extracts the major version from a server_version string
+// such as "16.4 (Debian 16.4-1.pgdg120+1)"
+func MajorVersion(version string) string {
+	version, _, _ = strings.Cut(strings.TrimSpace(version), " ")
+	major, _, _ := strings.Cut(version, ".")
+	return major
+}
+
+// dumpEntry is the archive path of a database's pg_dump output
+func dumpEntry(database string) string {
+	return "databases/" + database + ".dump"
+}
+
+// Dump writes a tar archive to path holding metadata.json and a
+// pg_dump custom-format (compressed) dump of each database. The archive is
+// written to a temporary file first so a failed dump leaves nothing behind.
+func Dump(ctx context.Context, pg *Postgres, project string, databases []string, path string) (*DumpMetadata, error) {
+	version, err := pg.ServerVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &DumpMetadata{
+		Created:       time.Now().UTC(),
+		Project:       project,
+		Image:         pg.image,
+		ImageID:       pg.imageID,
+		ServerVersion: version,
+		Databases:     databases,
+		Format:        "pg_dump-custom",
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".db-dump-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	tw := tar.NewWriter(tmp)
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarEntry(tw, dumpMetadataFile, bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	for _, db := range databases {
+		if err := dumpDatabase(ctx, pg, tw, filepath.Dir(path), db); err != nil {
+			return nil, fmt.Errorf("failed to dump %s: %w", db, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	os.Chmod(path, 0600)
+
+	return meta, nil
+}
+
+// dumpDatabase streams pg_dump output to a scratch file, since tar needs
+// each entry's size up front, then appends it to the archive
+func dumpDatabase(ctx context.Context, pg *Postgres, tw *tar.Writer, dir, database string) error {
+	scratch, err := os.CreateTemp(dir, ".pg_dump-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(scratch.Name())
+	defer scratch.Close()
+
+	var stderr bytes.Buffer
+	cmd := []string{"pg_dump", "-Fc", "-U", pg.user, "-d", database}
+	exitCode, err := pg.client.ContainerExecStream(ctx, pg.containerID, cmd, pg.env(), nil, scratch, &stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("pg_dump exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+
+	size, err := scratch.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := scratch.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return writeTarEntry(tw, dumpEntry(database), scratch, size)
+}
+
+// writeTarEntry adds a regular file to the archive
+func writeTarEntry(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// ReadDumpMetadata returns the metadata of a dump archive
+func ReadDumpMetadata(path string) (*DumpMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != dumpMetadataFile {
+		return nil, fmt.Errorf("%s is not a database dump", path)
+	}
+
+	var meta DumpMetadata
+	if err := json.NewDecoder(tr).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("invalid dump metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// Restore restores the given databases from a dump archive, dropping and
+// recreating each one. Clients of the databases must be stopped first.
+func Restore(ctx context.Context, pg *Postgres, path string, databases []string, report func(database string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	wanted := make(map[string]bool, len(databases))
+	for _, db := range databases {
+		wanted[dumpEntry(db)] = true
+	}
+
+	tr := tar.NewReader(f)
+	restored := 0
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dump: %w", err)
+		}
+		if !wanted[hdr.Name] {
+			continue
+		}
+
+		db := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, "databases/"), ".dump")
+		report(db)
+		if err := pg.restoreDatabase(ctx, db, tr); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", db, err)
+		}
+		restored++
+	}
+
+	if restored != len(databases) {
+		return fmt.Errorf("restored %d of %d databases; the dump is missing the rest", restored, len(databases))
+	}
+	return nil
+}
+
+// restoreDatabase feeds a custom-format dump to pg_restore, which drops the
+// database, recreates it and loads its contents
+func (p *Postgres) restoreDatabase(ctx context.Context, database string, dump io.Reader) error {
+	// With --clean --create the dumped database is dropped and recreated,
+	// so pg_restore has to start from the maintenance database
+	cmd := []string{"pg_restore", "-U", p.user, "-d", "postgres", "--clean", "--if-exists", "--create", "--exit-on-error"}
+
+	var stderr bytes.Buffer
+	exitCode, err := p.client.ContainerExecStream(ctx, p.containerID, cmd, p.env(), dump, io.Discard, &stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("pg_restore exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
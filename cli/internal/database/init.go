@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultGuacamoleImage provides the Guacamole schema when the compose
+// file does not name an image
+const DefaultGuacamoleImage = "guacamole/guacamole"
+
+// guacamoleSchemaTable is created by the Guacamole schema
+const guacamoleSchemaTable = "guacamole_user"
+
+// InitConfig holds the .env settings used to initialise the databases
+type InitConfig struct {
+	User              string
+	Password          string
+	AuthentikDatabase string
+	GuacamoleDatabase string
+	GuacamoleImage    string
+}
+
+// InitConfigFromEnv reads the database settings from .env values
+func InitConfigFromEnv(env map[string]string, guacamoleImage string) (InitConfig, error) {
+	cfg := InitConfig{
+		User:              env["POSTGRESQL_USERNAME"],
+		Password:          env["POSTGRESQL_PASSWORD"],
+		AuthentikDatabase: env["AUTHENTIK_DATABASE"],
+		GuacamoleDatabase: env["GUACAMOLE_DATABASE"],
+		GuacamoleImage:    guacamoleImage,
+	}
+	if cfg.GuacamoleImage == "" {
+		cfg.GuacamoleImage = DefaultGuacamoleImage
+	}
+
+	var missing []string
+	for _, v := range []struct{ name, value string }{
+		{"POSTGRESQL_USERNAME", cfg.User},
+		{"POSTGRESQL_PASSWORD", cfg.Password},
+		{"AUTHENTIK_DATABASE", cfg.AuthentikDatabase},
+		{"GUACAMOLE_DATABASE", cfg.GuacamoleDatabase},
+	} {
+		if v.value == "" {
+			missing = append(missing, v.name)
+		}
+	}
+	if len(missing) > 0 {
+		return cfg, fmt.Errorf("missing required environment variables: %v", missing)
+	}
+
+	return cfg, nil
+}
+
+// Step is one idempotent part of database initialisation
+type Step struct {
+	Description string
+	Database    string // database the step runs in
+	SQL         string // empty for the schema step
+	Display     string // SQL with the password masked, for --dry-run
+	Schema      bool   // load the Guacamole schema from its image
+	Always      bool   // re-applied on every run (grants)
+	Done        bool   // already applied
+}
+
+// PlanInit works out which initialisation steps still need to run. With a
+// nil pg the current state is unknown and every step is pending.
+func PlanInit(ctx context.Context, pg *Postgres, cfg InitConfig) ([]Step, error) {
+	authentikExists, guacamoleExists, schemaLoaded := false, false, false
+	if pg != nil {
+		var err error
+		if authentikExists, err = pg.DatabaseExists(ctx, cfg.AuthentikDatabase); err != nil {
+			return nil, err
+		}
+		if guacamoleExists, err = pg.DatabaseExists(ctx, cfg.GuacamoleDatabase); err != nil {
+			return nil, err
+		}
+		if guacamoleExists {
+			if schemaLoaded, err = pg.TableExists(ctx, cfg.GuacamoleDatabase, guacamoleSchemaTable); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	steps := []Step{
+		createDatabaseStep(cfg.AuthentikDatabase, authentikExists),
+		grantStep(cfg, cfg.AuthentikDatabase),
+		createDatabaseStep(cfg.GuacamoleDatabase, guacamoleExists),
+		{
+			Description: fmt.Sprintf("Load Guacamole schema into %s", cfg.GuacamoleDatabase),
+			Database:    cfg.GuacamoleDatabase,
+			Display:     fmt.Sprintf("-- output of: docker run --rm %s /opt/guacamole/bin/initdb.sh --postgresql\n", cfg.GuacamoleImage),
+			Schema:      true,
+			Done:        schemaLoaded,
+		},
+		grantStep(cfg, cfg.GuacamoleDatabase),
+	}
+	return steps, nil
+}
+
+// NeedsInit reports whether any database or schema is missing. Grants are
+// not considered since they are re-applied on every run.
+func NeedsInit(steps []Step) bool {
+	for _, s := range steps {
+		if !s.Done && !s.Always {
+			return true
+		}
+	}
+	return false
+}
+
+// RunInit applies every pending step, reporting progress through report
+func RunInit(ctx context.Context, pg *Postgres, cfg InitConfig, steps []Step, report func(step Step)) error {
+	for _, step := range steps {
+		if step.Done {
+			continue
+		}
+		report(step)
+
+		if step.Schema {
+			schema, err := pg.client.RunImage(ctx, cfg.GuacamoleImage, []string{"/opt/guacamole/bin/initdb.sh", "--postgresql"})
+			if err != nil {
+				return fmt.Errorf("failed to generate Guacamole schema: %w", err)
+			}
+			if err := pg.Exec(ctx, step.Database, schema); err != nil {
+				return fmt.Errorf("failed to load Guacamole schema: %w", err)
+			}
+			continue
+		}
+
+		// Scripts are fed on stdin, where psql commits each statement on
+		// its own, so CREATE DATABASE is not caught in a transaction
+		if err := pg.Exec(ctx, step.Database, step.SQL); err != nil {
+			return fmt.Errorf("%s: %w", step.Description, err)
+		}
+	}
+	return nil
+}
+
+// createDatabaseStep creates a database owned by the connecting user
+func createDatabaseStep(name string, exists bool) Step {
+	sql := fmt.Sprintf("CREATE DATABASE %s;\n", QuoteIdent(name))
+	return Step{
+		Description: fmt.Sprintf("Create database %s", name),
+		Database:    "postgres",
+		SQL:         sql,
+		Display:     sql,
+		Done:        exists,
+	}
+}
+
+// grantStep ensures the role exists and can use every table and sequence
+// in database, including ones created later
+func grantStep(cfg InitConfig, database string) Step {
+	build := func(password string) string {
+		role := QuoteIdent(cfg.User)
+		var b strings.Builder
+		fmt.Fprintf(&b, "DO $$\nBEGIN\n   IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = %s) THEN\n      CREATE USER %s WITH PASSWORD %s;\n   END IF;\nEND\n$$;\n\n",
+			QuoteLiteral(cfg.User), role, QuoteLiteral(password))
+		fmt.Fprintf(&b, "GRANT CONNECT ON DATABASE %s TO %s;\n", QuoteIdent(database), role)
+		fmt.Fprintf(&b, "GRANT USAGE ON SCHEMA public TO %s;\n", role)
+		fmt.Fprintf(&b, "GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO %s;\n", role)
+		fmt.Fprintf(&b, "GRANT SELECT, USAGE ON ALL SEQUENCES IN SCHEMA public TO %s;\n", role)
+		fmt.Fprintf(&b, "ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO %s;\n", role)
+		fmt.Fprintf(&b, "ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO %s;\n", role)
+		return b.String()
+	}
+
+	return Step{
+		Description: fmt.Sprintf("Grant %s access to %s", cfg.User, database),
+		Database:    database,
+		SQL:         build(cfg.Password),
+		Display:     build("********"),
+		Always:      true,
+	}
+}
@@ -0,0 +1,131 @@
+// Package database manages the stack's shared PostgreSQL server
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jxmullins/mediastack/internal/docker"
+)
+
+// Service is the compose service running the shared PostgreSQL server
+const Service = "postgresql"
+
+// Postgres runs psql inside the postgresql container
+type Postgres struct {
+	client      *docker.Client
+	containerID string
+	image       string
+	imageID     string
+	user        string
+	password    string
+}
+
+// NewPostgres connects to the running postgresql container as user
+func NewPostgres(ctx context.Context, client *docker.Client, user, password string) (*Postgres, error) {
+	cont, err := client.FindContainer(ctx, Service)
+	if err != nil {
+		return nil, err
+	}
+	if cont.State != "running" {
+		return nil, fmt.Errorf("%s is not running (state: %s)", Service, cont.State)
+	}
+
+	return &Postgres{
+		client:      client,
+		containerID: cont.ID,
+		image:       cont.Image,
+		imageID:     cont.ImageID,
+		user:        user,
+		password:    password,
+	}, nil
+}
+
+// Query runs a single statement in database and returns its unaligned,
+// tuples-only output
+func (p *Postgres) Query(ctx context.Context, database, sql string) (string, error) {
+	out, err := p.psql(ctx, database, []string{"-tA", "-c", sql}, "")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Exec runs a SQL script in database, stopping at the first error
+func (p *Postgres) Exec(ctx context.Context, database, script string) error {
+	_, err := p.psql(ctx, database, []string{"-q"}, script)
+	return err
+}
+
+// DatabaseExists reports whether a database exists
+func (p *Postgres) DatabaseExists(ctx context.Context, name string) (bool, error) {
+	out, err := p.Query(ctx, "postgres", "SELECT 1 FROM pg_database WHERE datname = "+QuoteLiteral(name))
+	if err != nil {
+		return false, err
+	}
+	return out == "1", nil
+}
+
+// TableExists reports whether a table exists in the public schema of database
+func (p *Postgres) TableExists(ctx context.Context, database, table string) (bool, error) {
+	out, err := p.Query(ctx, database, "SELECT to_regclass("+QuoteLiteral("public."+table)+") IS NOT NULL")
+	if err != nil {
+		return false, err
+	}
+	return out == "t", nil
+}
+
+// ServerVersion returns the version reported by the server, e.g. "16.4"
+func (p *Postgres) ServerVersion(ctx context.Context) (string, error) {
+	return p.Query(ctx, "postgres", "SHOW server_version")
+}
+
+// ListDatabases returns the user databases on the server, excluding
+// templates and the postgres maintenance database
+func (p *Postgres) ListDatabases(ctx context.Context) ([]string, error) {
+	out, err := p.Query(ctx, "postgres", "SELECT datname FROM pg_database WHERE NOT datistemplate AND datname <> 'postgres' ORDER BY datname")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// psql runs psql with args, feeding it script on stdin when not empty
+func (p *Postgres) psql(ctx context.Context, database string, args []string, script string) (string, error) {
+	cmd := append([]string{"psql", "-v", "ON_ERROR_STOP=1", "-U", p.user, "-d", database}, args...)
+	env := p.env()
+
+	var result *docker.ExecResult
+	var err error
+	if script != "" {
+		result, err = p.client.ContainerExecInput(ctx, p.containerID, cmd, env, strings.NewReader(script))
+	} else {
+		result, err = p.client.ContainerExecInput(ctx, p.containerID, cmd, env, nil)
+	}
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf("psql failed (exit code %d): %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return result.Stdout, nil
+}
+
+// QuoteIdent quotes a PostgreSQL identifier such as a database or role name
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteLiteral quotes a PostgreSQL string literal
+func QuoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// env is the environment psql and pg_dump authenticate with
+func (p *Postgres) env() []string {
+	return []string{"PGPASSWORD=" + p.password}
+}
@@ -0,0 +1,384 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// CopyOptions controls ownership when copying between host and container
+type CopyOptions struct {
+	Chown bool // Set ownership of copied files to UID/GID
+	UID   int
+	GID   int
+}
+
+// CopyStats reports what a copy could not fully apply
+type CopyStats struct {
+	ChownFailed int // Copied files whose ownership could not be set
+}
+
+// ParseCopyPath splits a "service:path" argument. An argument without a
+// service prefix refers to the host and returns an empty service.
+func ParseCopyPath(arg string) (service, p string) {
+	idx := strings.Index(arg, ":")
+	if idx <= 0 {
+		return "", arg
+	}
+
+	// A drive letter, as in C:\tmp or C:/tmp, starts a Windows host path
+	if idx == 1 && len(arg) > 2 && (arg[2] == '\\' || arg[2] == '/') && isLetter(arg[0]) {
+		return "", arg
+	}
+
+	// Anything with a path separator before the colon is a host path
+	if strings.ContainsAny(arg[:idx], `/\.`) {
+		return "", arg
+	}
+
+	return arg[:idx], arg[idx+1:]
+}
+
+// isLetter reports whether b is an ASCII letter
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// Copy copies files or directories between the host and a service container.
+// Exactly one of src and dst must be in "service:path" form.
+func (c *Client) Copy(ctx context.Context, src, dst string, opts CopyOptions) (CopyStats, error) {
+	srcService, srcPath := ParseCopyPath(src)
+	dstService, dstPath := ParseCopyPath(dst)
+
+	switch {
+	case srcService != "" && dstService != "":
+		return CopyStats{}, fmt.Errorf("copying between containers is not supported")
+	case srcService == "" && dstService == "":
+		return CopyStats{}, fmt.Errorf("one of source or destination must be service:path")
+	case srcService != "":
+		cont, err := c.FindContainer(ctx, srcService)
+		if err != nil {
+			return CopyStats{}, err
+		}
+		return c.CopyFromContainer(ctx, cont.ID, srcPath, dstPath, opts)
+	default:
+		cont, err := c.FindContainer(ctx, dstService)
+		if err != nil {
+			return CopyStats{}, err
+		}
+		return CopyStats{}, c.CopyToContainer(ctx, cont.ID, srcPath, dstPath, opts)
+	}
+}
+
+// CopyFromContainer copies a file or directory out of a container. If dst is
+// an existing directory the source is placed inside it, otherwise it is
+// written to dst. Files whose ownership cannot be set for lack of
+// permission are counted rather than failing the copy.
+func (c *Client) CopyFromContainer(ctx context.Context, containerID, src, dst string, opts CopyOptions) (CopyStats, error) {
+	reader, stat, err := c.cli.CopyFromContainer(ctx, containerID, src)
+	if err != nil {
+		return CopyStats{}, fmt.Errorf("failed to copy from container: %w", err)
+	}
+	defer reader.Close()
+
+	// Entries in the archive are rooted at the base name of the source
+	rename := ""
+	destDir := dst
+	if info, err := os.Stat(dst); err != nil || !info.IsDir() {
+		destDir = filepath.Dir(dst)
+		rename = filepath.Base(dst)
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return CopyStats{}, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	return extractTar(reader, destDir, stat.Name, rename, opts)
+}
+
+// CopyToContainer copies a host file or directory into a container. If dst
+// is an existing directory in the container the source is placed inside it,
+// otherwise it is written to dst.
+func (c *Client) CopyToContainer(ctx context.Context, containerID, src, dst string, opts CopyOptions) error {
+	if _, err := os.Lstat(src); err != nil {
+		return fmt.Errorf("failed to read source: %w", err)
+	}
+
+	destDir := dst
+	name := filepath.Base(src)
+	if stat, err := c.cli.ContainerStatPath(ctx, containerID, dst); err != nil || !stat.Mode.IsDir() {
+		destDir = path.Dir(dst)
+		name = path.Base(dst)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(packTar(pw, src, name, opts))
+	}()
+
+	// CopyUIDGID would chown everything to the container's configured user,
+	// which is root for linuxserver.io images; the owner comes from the
+	// headers written by packTar instead
+	err := c.cli.CopyToContainer(ctx, containerID, destDir, pr, container.CopyToContainerOptions{
+		CopyUIDGID: false,
+	})
+	pr.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy to container: %w", err)
+	}
+
+	return nil
+}
+
+// ReadFileFromContainer reads a single file from inside a container
+func (c *Client) ReadFileFromContainer(ctx context.Context, containerID, filePath string) ([]byte, error) {
+	reader, _, err := c.cli.CopyFromContainer(ctx, containerID, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy file from container: %w", err)
+	}
+	defer reader.Close()
+
+	// The response is a tar archive; return the contents of the first file
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no regular file found at %s", filePath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// packTar writes src to w as a tar archive whose root entry is named name
+func packTar(w io.Writer, src, name string, opts CopyOptions) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		entry := filepath.ToSlash(filepath.Join(name, rel))
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = entry
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if opts.Chown {
+			hdr.Uid = opts.UID
+			hdr.Gid = opts.GID
+			hdr.Uname = ""
+			hdr.Gname = ""
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", src, err)
+	}
+
+	return tw.Close()
+}
+
+// extractTar extracts an archive into destDir. Entries under root are
+// renamed to rename when it is set. Paths escaping destDir are rejected.
+func extractTar(r io.Reader, destDir, root, rename string, opts CopyOptions) (CopyStats, error) {
+	tr := tar.NewReader(r)
+	var dirs []*tar.Header
+	var stats CopyStats
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		if rename != "" && root != "" {
+			if name == root {
+				name = rename
+			} else if strings.HasPrefix(name, root+"/") {
+				name = rename + strings.TrimPrefix(name, root)
+			}
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(name))
+		if !isWithin(destDir, target) {
+			return stats, fmt.Errorf("archive entry escapes destination: %s", hdr.Name)
+		}
+		if err := checkSymlinkParents(destDir, target); err != nil {
+			return stats, err
+		}
+		// Replace a symlink at target rather than writing through it
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return stats, err
+			}
+		}
+
+		mode := headerMode(hdr)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return stats, err
+			}
+			// Apply directory modes after their contents are written
+			h := *hdr
+			h.Name = target
+			dirs = append(dirs, &h)
+		case tar.TypeReg:
+			if err := writeFile(tr, target, mode); err != nil {
+				return stats, err
+			}
+		case tar.TypeSymlink:
+			linkTarget := filepath.Join(filepath.Dir(target), filepath.FromSlash(hdr.Linkname))
+			if path.IsAbs(hdr.Linkname) || filepath.IsAbs(hdr.Linkname) || !isWithin(destDir, linkTarget) {
+				return stats, fmt.Errorf("archive symlink escapes destination: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return stats, err
+			}
+		case tar.TypeLink:
+			linkTarget := filepath.Join(destDir, filepath.FromSlash(path.Clean(hdr.Linkname)))
+			if !isWithin(destDir, linkTarget) {
+				return stats, fmt.Errorf("archive link escapes destination: %s", hdr.Linkname)
+			}
+			os.Remove(target)
+			if err := os.Link(linkTarget, target); err != nil {
+				return stats, err
+			}
+		default:
+			continue
+		}
+
+		if opts.Chown {
+			if err := os.Lchown(target, opts.UID, opts.GID); errors.Is(err, os.ErrPermission) {
+				stats.ChownFailed++
+			} else if err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].Name, headerMode(dirs[i])); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// headerMode returns the permission bits of a tar entry, including setgid
+// which the data directories rely on
+func headerMode(hdr *tar.Header) os.FileMode {
+	return hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// writeFile writes r to path with the given mode
+func writeFile(r io.Reader, p string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// OpenFile does not change the mode of existing files and is subject to umask
+	return os.Chmod(p, mode)
+}
+
+// checkSymlinkParents rejects a target below a symlink inside dir, through
+// which an archive could otherwise write outside dir
+func checkSymlinkParents(dir, target string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	p := dir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %s is below symlink %s", target, p)
+		}
+	}
+	return nil
+}
+
+// isWithin reports whether target is inside dir
+func isWithin(dir, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -7,12 +7,16 @@ import (
 	"os"
 	"strings"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 )
 
+// inspectConcurrency bounds the number of parallel per-container API calls
+const inspectConcurrency = 8
+
 // Client wraps the Docker SDK client
 type Client struct {
 	cli         *client.Client
@@ -23,12 +27,16 @@ type Client struct {
 type ContainerInfo struct {
 	ID      string
 	Name    string
+	Service string
 	Image   string
+	ImageID string
 	State   string
 	Status  string
 	Health  string
 	Ports   []string
 	Created int64
+
+	ConfigHash string // Compose config hash the container was created from
 }
 
 // NewClient creates a new Docker client
@@ -55,11 +63,11 @@ func (c *Client) Ping(ctx context.Context) error {
 	return err
 }
 
-// ListContainers returns all containers for the project
-func (c *Client) ListContainers(ctx context.Context, all bool) ([]ContainerInfo, error) {
+// listProject lists the project's containers as the daemon reports them
+func (c *Client) listProject(ctx context.Context, all bool) ([]types.Container, error) {
 	filterArgs := filters.NewArgs()
 	if c.projectName != "" {
-		filterArgs.Add("label", fmt.Sprintf("com.docker.compose.project=%s", c.projectName))
+		filterArgs.Add("label", fmt.Sprintf("%s=%s", ProjectLabel, c.projectName))
 	}
 
 	containers, err := c.cli.ContainerList(ctx, container.ListOptions{
@@ -69,24 +77,35 @@ func (c *Client) ListContainers(ctx context.Context, all bool) ([]ContainerInfo,
 	if err != nil {
 		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
+	return containers, nil
+}
+
+// ListContainers returns all containers for the project
+func (c *Client) ListContainers(ctx context.Context, all bool) ([]ContainerInfo, error) {
+	containers, err := c.listProject(ctx, all)
+	if err != nil {
+		return nil, err
+	}
 
 	result := make([]ContainerInfo, 0, len(containers))
 	for _, cont := range containers {
 		info := ContainerInfo{
 			ID:      cont.ID[:12],
 			Name:    strings.TrimPrefix(cont.Names[0], "/"),
+			Service: cont.Labels[ServiceLabel],
 			Image:   cont.Image,
+			ImageID: cont.ImageID,
 			State:   cont.State,
 			Status:  cont.Status,
 			Created: cont.Created,
+
+			ConfigHash: cont.Labels[ConfigHashLabel],
 		}
 
-		// Get health status if available
+		// The daemon appends the health to the status of running
+		// containers that have a healthcheck
 		if cont.State == "running" {
-			inspect, err := c.cli.ContainerInspect(ctx, cont.ID)
-			if err == nil && inspect.State.Health != nil {
-				info.Health = inspect.State.Health.Status
-			}
+			info.Health = parseHealthFromStatus(cont.Status)
 		}
 
 		// Format ports
@@ -102,6 +121,21 @@ func (c *Client) ListContainers(ctx context.Context, all bool) ([]ContainerInfo,
 	return result, nil
 }
 
+// parseHealthFromStatus extracts the health status from a container list
+// status string such as "Up 2 hours (healthy)" or "Up 5 seconds (health: starting)".
+// It is empty for containers without a healthcheck.
+func parseHealthFromStatus(status string) string {
+	switch {
+	case strings.HasSuffix(status, "(healthy)"):
+		return "healthy"
+	case strings.HasSuffix(status, "(unhealthy)"):
+		return "unhealthy"
+	case strings.HasSuffix(status, "(health: starting)"):
+		return "starting"
+	}
+	return ""
+}
+
 // StopContainer stops a container by ID or name
 func (c *Client) StopContainer(ctx context.Context, containerID string) error {
 	timeout := 30 // seconds
@@ -159,55 +193,92 @@ func (c *Client) GetContainerLogs(ctx context.Context, containerID string, follo
 	})
 }
 
-// ContainerExec executes a command in a container
-func (c *Client) ContainerExec(ctx context.Context, containerID string, cmd []string) (string, error) {
-	execConfig := container.ExecOptions{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          cmd,
+// ImageID returns the ID of a local image, or an empty string if it has not
+// been pulled
+func (c *Client) ImageID(ctx context.Context, ref string) (string, error) {
+	inspect, _, err := c.cli.ImageInspectWithRaw(ctx, ref)
+	if client.IsErrNotFound(err) {
+		return "", nil
 	}
-
-	execID, err := c.cli.ContainerExecCreate(ctx, containerID, execConfig)
 	if err != nil {
-		return "", fmt.Errorf("failed to create exec: %w", err)
+		return "", err
 	}
+	return inspect.ID, nil
+}
 
-	resp, err := c.cli.ContainerExecAttach(ctx, execID.ID, container.ExecStartOptions{})
+// ServiceImageIDs returns the image ID of each service's container,
+// including stopped ones
+func (c *Client) ServiceImageIDs(ctx context.Context) (map[string]string, error) {
+	containers, err := c.ListContainers(ctx, true)
 	if err != nil {
-		return "", fmt.Errorf("failed to attach to exec: %w", err)
+		return nil, err
 	}
-	defer resp.Close()
 
-	output, err := io.ReadAll(resp.Reader)
-	if err != nil {
-		return "", fmt.Errorf("failed to read exec output: %w", err)
+	images := make(map[string]string, len(containers))
+	for _, cont := range containers {
+		if cont.Service != "" {
+			images[cont.Service] = cont.ImageID
+		}
 	}
-
-	return string(output), nil
+	return images, nil
 }
 
-// PruneContainers removes all stopped containers
-func (c *Client) PruneContainers(ctx context.Context) error {
-	_, err := c.cli.ContainersPrune(ctx, filters.Args{})
-	return err
+// ImageRefIDs returns the ID of the local image each service's image
+// reference points to, which changes when a pull fetches a new image even
+// before the containers are recreated. Services whose image is not pulled
+// are left out.
+func (c *Client) ImageRefIDs(ctx context.Context, project *Project) (map[string]string, error) {
+	byRef := make(map[string]string)
+	images := make(map[string]string, len(project.Services))
+	for name, svc := range project.Services {
+		if svc.Image == "" {
+			continue
+		}
+		id, ok := byRef[svc.Image]
+		if !ok {
+			var err error
+			if id, err = c.ImageID(ctx, svc.Image); err != nil {
+				return nil, fmt.Errorf("failed to inspect image %s: %w", svc.Image, err)
+			}
+			byRef[svc.Image] = id
+		}
+		if id != "" {
+			images[name] = id
+		}
+	}
+	return images, nil
 }
 
-// PruneVolumes removes all unused volumes
-func (c *Client) PruneVolumes(ctx context.Context) error {
-	_, err := c.cli.VolumesPrune(ctx, filters.Args{})
-	return err
+// ContainerMount is a mount of a project container as the daemon reports it
+type ContainerMount struct {
+	Service     string
+	Type        string
+	Source      string
+	Destination string
+	RW          bool
 }
 
-// PruneNetworks removes all unused networks
-func (c *Client) PruneNetworks(ctx context.Context) error {
-	_, err := c.cli.NetworksPrune(ctx, filters.Args{})
-	return err
-}
+// ContainerMounts returns the mounts of the project's containers,
+// including stopped ones
+func (c *Client) ContainerMounts(ctx context.Context) ([]ContainerMount, error) {
+	containers, err := c.listProject(ctx, true)
+	if err != nil {
+		return nil, err
+	}
 
-// PruneImages removes all unused images
-func (c *Client) PruneImages(ctx context.Context) error {
-	_, err := c.cli.ImagesPrune(ctx, filters.NewArgs(filters.Arg("dangling", "false")))
-	return err
+	var mounts []ContainerMount
+	for _, cont := range containers {
+		for _, m := range cont.Mounts {
+			mounts = append(mounts, ContainerMount{
+				Service:     cont.Labels[ServiceLabel],
+				Type:        string(m.Type),
+				Source:      m.Source,
+				Destination: m.Destination,
+				RW:          m.RW,
+			})
+		}
+	}
+	return mounts, nil
 }
 
 // PullImage pulls a Docker image
@@ -223,33 +294,35 @@ func (c *Client) PullImage(ctx context.Context, imageName string) error {
 	return err
 }
 
-// FindContainer finds a container by service name
+// FindContainer finds a container by exact compose service name or alias.
+// If no service matches, the error lists the closest service names.
 func (c *Client) FindContainer(ctx context.Context, serviceName string) (*ContainerInfo, error) {
 	containers, err := c.ListContainers(ctx, true)
 	if err != nil {
 		return nil, err
 	}
 
+	services := make([]string, 0, len(containers))
 	for _, cont := range containers {
-		// Check if container name contains service name
-		if strings.Contains(cont.Name, serviceName) {
-			return &cont, nil
+		if cont.Service != "" {
+			services = append(services, cont.Service)
+		} else {
+			services = append(services, cont.Name)
 		}
 	}
 
-	return nil, fmt.Errorf("container not found for service: %s", serviceName)
-}
-
-// ReadFileFromContainer reads a file from inside a container
-func (c *Client) ReadFileFromContainer(ctx context.Context, containerID, filePath string) ([]byte, error) {
-	reader, _, err := c.cli.CopyFromContainer(ctx, containerID, filePath)
+	service, err := ResolveService(serviceName, services)
 	if err != nil {
-		return nil, fmt.Errorf("failed to copy file from container: %w", err)
+		return nil, err
 	}
-	defer reader.Close()
 
-	// The response is a tar archive, we need to extract it
-	return io.ReadAll(reader)
+	for _, cont := range containers {
+		if cont.Service == service || (cont.Service == "" && cont.Name == service) {
+			return &cont, nil
+		}
+	}
+
+	return nil, fmt.Errorf("container not found for service: %s", serviceName)
 }
 
 // CheckDockerRunning verifies Docker daemon is accessible
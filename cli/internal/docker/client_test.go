@@ -0,0 +1,234 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+)
+
+// fakeLatency is the simulated round trip of each Docker API call
+const fakeLatency = 2 * time.Millisecond
+
+// fakeDocker is a Docker API serving a project's running containers
+type fakeDocker struct {
+	client   *Client
+	inspects atomic.Int32 // Number of ContainerInspect calls served
+}
+
+// newFakeDocker serves a Docker API with n running containers of project.
+// Even-numbered containers have a healthcheck and report their health in
+// their status, as the daemon does; each has a bind mount of its config.
+func newFakeDocker(tb testing.TB, project string, n int) *fakeDocker {
+	tb.Helper()
+
+	fake := &fakeDocker{}
+	containers := make([]types.Container, n)
+	for i := range containers {
+		status := "Up 2 hours"
+		if i%2 == 0 {
+			status += " (healthy)"
+		}
+		containers[i] = types.Container{
+			ID:      fmt.Sprintf("%012x%052x", i+1, 0),
+			Names:   []string{fmt.Sprintf("/service%d", i)},
+			Image:   "example/image:latest",
+			ImageID: "sha256:0123",
+			State:   "running",
+			Status:  status,
+			Labels: map[string]string{
+				ProjectLabel: project,
+				ServiceLabel: fmt.Sprintf("service%d", i),
+			},
+			Mounts: []types.MountPoint{{
+				Type:        mount.TypeBind,
+				Source:      fmt.Sprintf("/srv/config/service%d", i),
+				Destination: "/config",
+				RW:          true,
+			}},
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(fakeLatency)
+		w.Header().Set("Content-Type", "application/json")
+
+		// Strip the /v1.xx prefix
+		path := r.URL.Path
+		if parts := strings.SplitN(path, "/", 3); len(parts) == 3 && strings.HasPrefix(parts[1], "v") {
+			path = "/" + parts[2]
+		}
+
+		switch {
+		case path == "/containers/json":
+			json.NewEncoder(w).Encode(containers)
+		case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/stats"):
+			id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/stats")
+			if id == containers[0].ID[:12] {
+				// The first container's stats are unavailable
+				http.Error(w, `{"message":"no stats"}`, http.StatusInternalServerError)
+				return
+			}
+			stats := container.StatsResponse{Name: "/" + id}
+			stats.MemoryStats.Usage = 1024
+			stats.MemoryStats.Limit = 4096
+			json.NewEncoder(w).Encode(stats)
+			if r.URL.Query().Get("stream") != "1" {
+				return
+			}
+			// Keep the stream open like the daemon does
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+			fake.inspects.Add(1)
+			id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+			json.NewEncoder(w).Encode(types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{
+					ID: id,
+					State: &types.ContainerState{
+						Status:  "running",
+						Running: true,
+						Health:  &types.Health{Status: "healthy"},
+					},
+				},
+				Config: &container.Config{},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	tb.Cleanup(server.Close)
+
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+server.Listener.Addr().String()),
+		client.WithVersion("1.45"),
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { cli.Close() })
+
+	fake.client = &Client{cli: cli, projectName: project}
+	return fake
+}
+
+// listContainersSerial lists the containers and inspects each one for its
+// health, as ListContainers did before parsing the health from the status
+func listContainersSerial(ctx context.Context, c *Client) ([]ContainerInfo, error) {
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ContainerInfo, 0, len(containers))
+	for _, cont := range containers {
+		info := ContainerInfo{ID: cont.ID[:12], State: cont.State}
+		inspect, err := c.cli.ContainerInspect(ctx, cont.ID)
+		if err == nil && inspect.State != nil && inspect.State.Health != nil {
+			info.Health = inspect.State.Health.Status
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func TestListContainersParsesHealth(t *testing.T) {
+	fake := newFakeDocker(t, "mediastack", 20)
+
+	containers, err := fake.client.ListContainers(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 20 {
+		t.Fatalf("got %d containers, want 20", len(containers))
+	}
+	for i, cont := range containers {
+		want := ""
+		if i%2 == 0 {
+			want = "healthy"
+		}
+		if cont.Health != want {
+			t.Errorf("%s: health %q, want %q", cont.Name, cont.Health, want)
+		}
+	}
+	if n := fake.inspects.Load(); n != 0 {
+		t.Errorf("ListContainers inspected %d containers, want 0", n)
+	}
+}
+
+func TestContainerMounts(t *testing.T) {
+	fake := newFakeDocker(t, "mediastack", 5)
+
+	mounts, err := fake.client.ContainerMounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 5 {
+		t.Fatalf("got %d mounts, want 5", len(mounts))
+	}
+	for _, m := range mounts {
+		if m.Source != "/srv/config/"+m.Service || m.Destination != "/config" || m.Type != "bind" || !m.RW {
+			t.Errorf("unexpected mount %+v", m)
+		}
+	}
+	if n := fake.inspects.Load(); n != 0 {
+		t.Errorf("ContainerMounts inspected %d containers, want 0", n)
+	}
+}
+
+func TestStatsMonitorSample(t *testing.T) {
+	monitor := newFakeDocker(t, "mediastack", 5).client.NewStatsMonitor()
+	defer monitor.Close()
+
+	for round := 0; round < 2; round++ {
+		stats, err := monitor.Sample(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 5 {
+			t.Fatalf("round %d: got %d rows, want 5", round, len(stats))
+		}
+		for i, s := range stats {
+			switch {
+			case i == 0 && s.Error == "":
+				t.Errorf("round %d: %s has no error", round, s.Name)
+			case i > 0 && (s.Error != "" || s.MemPercent != 25):
+				t.Errorf("round %d: %s = %+v, want 25%% memory", round, s.Name, s)
+			}
+		}
+	}
+}
+
+func BenchmarkListContainers(b *testing.B) {
+	ctx := context.Background()
+	c := newFakeDocker(b, "mediastack", 30).client
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := listContainersSerial(ctx, c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("parsed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := c.ListContainers(ctx, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
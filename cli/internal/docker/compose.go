@@ -96,6 +96,26 @@ func (c *Compose) runCommandOutput(ctx context.Context, args []string) (string,
 	return string(output), nil
 }
 
+// runCommandStdout executes a command and returns only its stdout, so
+// warnings on stderr do not corrupt machine-readable output
+func (c *Compose) runCommandStdout(ctx context.Context, args []string) (string, error) {
+	fullArgs := append(c.baseArgs(), args...)
+
+	cmd := exec.CommandContext(ctx, "docker", fullArgs...)
+	cmd.Dir = c.configDir
+	cmd.Env = os.Environ()
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, stderr.String())
+	}
+
+	return string(output), nil
+}
+
 // Config validates the compose configuration
 func (c *Compose) Config(ctx context.Context) error {
 	return c.runCommand(ctx, []string{"config", "--quiet"}, false)
@@ -177,6 +197,11 @@ func (c *Compose) Start(ctx context.Context) error {
 	return c.runCommand(ctx, []string{"start"}, true)
 }
 
+// StartService starts a specific stopped service
+func (c *Compose) StartService(ctx context.Context, service string) error {
+	return c.runCommand(ctx, []string{"start", service}, true)
+}
+
 // Restart restarts all services
 func (c *Compose) Restart(ctx context.Context) error {
 	fmt.Println("Restarting services...")
@@ -0,0 +1,146 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ServiceLabel is the label compose sets to the service name on each container
+const ServiceLabel = "com.docker.compose.service"
+
+// ProjectLabel is the label compose sets to the project name on each container
+const ProjectLabel = "com.docker.compose.project"
+
+// Event is a container lifecycle event for a project service
+type Event struct {
+	Time        time.Time `json:"time"`
+	Service     string    `json:"service"`
+	Container   string    `json:"container"`
+	ContainerID string    `json:"container_id"`
+	Action      string    `json:"action"`
+	ExitCode    string    `json:"exit_code,omitempty"`
+	Health      string    `json:"health,omitempty"`
+}
+
+// trackedActions are the container actions reported by WatchEvents
+var trackedActions = map[string]bool{
+	"create":  true,
+	"start":   true,
+	"restart": true,
+	"stop":    true,
+	"kill":    true,
+	"die":     true,
+	"oom":     true,
+	"destroy": true,
+	"pause":   true,
+	"unpause": true,
+}
+
+// WatchEvents streams container events for the project. If since is set,
+// past events from that point (timestamp or duration such as 10m) are
+// replayed before live events. The event channel is closed when the stream
+// ends; a non-nil error is sent on the error channel unless the context
+// was cancelled.
+func (c *Client) WatchEvents(ctx context.Context, since string) (<-chan Event, <-chan error) {
+	filterArgs := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	if c.projectName != "" {
+		filterArgs.Add("label", fmt.Sprintf("%s=%s", ProjectLabel, c.projectName))
+	}
+
+	// Map container IDs to services up front for events without labels
+	services := make(map[string]string)
+	if containers, err := c.ListContainers(ctx, true); err == nil {
+		for _, cont := range containers {
+			services[cont.ID] = cont.Service
+		}
+	}
+
+	messages, errs := c.cli.Events(ctx, events.ListOptions{
+		Since:   since,
+		Filters: filterArgs,
+	})
+
+	out := make(chan Event)
+	outErr := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(outErr)
+
+		for {
+			select {
+			case msg := <-messages:
+				ev, ok := convertEvent(msg, services)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return
+				}
+			case err := <-errs:
+				if err != nil && ctx.Err() == nil {
+					outErr <- fmt.Errorf("event stream failed: %w", err)
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, outErr
+}
+
+// convertEvent turns a Docker event message into an Event, reporting false
+// for actions that are not tracked
+func convertEvent(msg events.Message, services map[string]string) (Event, bool) {
+	action := string(msg.Action)
+	health := ""
+
+	// Health events arrive as "health_status: healthy"
+	if strings.HasPrefix(action, "health_status") {
+		health = strings.TrimSpace(strings.TrimPrefix(action, "health_status:"))
+		action = "health_status"
+	} else if !trackedActions[action] {
+		return Event{}, false
+	}
+
+	id := msg.Actor.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	attrs := msg.Actor.Attributes
+	service := attrs[ServiceLabel]
+	if service == "" {
+		service = services[id]
+	}
+	if service == "" {
+		service = attrs["name"]
+	}
+	if service != "" {
+		services[id] = service
+	}
+
+	ts := time.Unix(0, msg.TimeNano)
+	if msg.TimeNano == 0 {
+		ts = time.Unix(msg.Time, 0)
+	}
+
+	return Event{
+		Time:        ts,
+		Service:     service,
+		Container:   attrs["name"],
+		ContainerID: id,
+		Action:      action,
+		ExitCode:    attrs["exitCode"],
+		Health:      health,
+	}, true
+}
@@ -0,0 +1,229 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ExecResult holds the separated output and exit code of an exec
+type ExecResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// ServiceExecResult is the outcome of running a command in one service
+type ServiceExecResult struct {
+	Service   string        `json:"service"`
+	Container string        `json:"container,omitempty"`
+	State     string        `json:"state"`
+	Stdout    string        `json:"stdout"`
+	Stderr    string        `json:"stderr"`
+	ExitCode  int           `json:"exit_code"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Skipped reports whether the service had no running container
+func (r ServiceExecResult) Skipped() bool {
+	return r.State != "running"
+}
+
+// Failed reports whether the command could not run or exited non-zero
+func (r ServiceExecResult) Failed() bool {
+	return !r.Skipped() && (r.Error != "" || r.ExitCode != 0)
+}
+
+// ContainerExec executes a command in a container and returns its stdout,
+// stderr and exit code
+func (c *Client) ContainerExec(ctx context.Context, containerID string, cmd []string) (*ExecResult, error) {
+	return c.ContainerExecInput(ctx, containerID, cmd, nil, nil)
+}
+
+// ContainerExecInput executes a command in a container with extra
+// environment variables, feeding it stdin when not nil
+func (c *Client) ContainerExecInput(ctx context.Context, containerID string, cmd, env []string, stdin io.Reader) (*ExecResult, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := c.ContainerExecStream(ctx, containerID, cmd, env, stdin, &stdout, &stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExecResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+	}, nil
+}
+
+// ContainerExecStream executes a command in a container, streaming its
+// output to stdout and stderr, and returns its exit code. stdin is fed to
+// the command when not nil.
+func (c *Client) ContainerExecStream(ctx context.Context, containerID string, cmd, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	execConfig := container.ExecOptions{
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          env,
+		Cmd:          cmd,
+	}
+
+	execID, err := c.cli.ContainerExecCreate(ctx, containerID, execConfig)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	resp, err := c.cli.ContainerExecAttach(ctx, execID.ID, container.ExecStartOptions{})
+	if err != nil {
+		return -1, fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer resp.Close()
+
+	if stdin != nil {
+		go func() {
+			io.Copy(resp.Conn, stdin)
+			resp.CloseWrite()
+		}()
+	}
+
+	// Without a TTY the stream is multiplexed; split it back into stdout and stderr
+	if _, err := stdcopy.StdCopy(stdout, stderr, resp.Reader); err != nil {
+		return -1, fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	inspect, err := c.cli.ContainerExecInspect(ctx, execID.ID)
+	if err != nil {
+		return -1, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+
+	return inspect.ExitCode, nil
+}
+
+// RunImage runs cmd in a new container from image and returns its stdout.
+// The image is pulled if it is not present and the container is removed
+// afterwards.
+func (c *Client) RunImage(ctx context.Context, image string, cmd []string) (string, error) {
+	id, err := c.ImageID(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		if err := c.PullImage(ctx, image); err != nil {
+			return "", fmt.Errorf("failed to pull %s: %w", image, err)
+		}
+	}
+
+	created, err := c.cli.ContainerCreate(ctx, &container.Config{Image: image, Cmd: cmd}, nil, nil, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	defer c.cli.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+
+	if err := c.cli.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start container: %w", err)
+	}
+
+	var exitCode int64
+	waitCh, errCh := c.cli.ContainerWait(ctx, created.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return "", fmt.Errorf("failed waiting for container: %w", err)
+	case status := <-waitCh:
+		exitCode = status.StatusCode
+	}
+
+	logs, err := c.cli.ContainerLogs(ctx, created.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to read container output: %w", err)
+	}
+	defer logs.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, logs); err != nil {
+		return "", fmt.Errorf("failed to read container output: %w", err)
+	}
+
+	if exitCode != 0 {
+		return "", fmt.Errorf("%s exited with code %d: %s", image, exitCode, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.String(), nil
+}
+
+// ExecServices runs cmd concurrently in each of the given services, at most
+// parallel at a time. Services without a running container are reported
+// with their state instead of being skipped. Results are sorted by service.
+func (c *Client) ExecServices(ctx context.Context, services []string, cmd []string, parallel int, timeout time.Duration) ([]ServiceExecResult, error) {
+	containers, err := c.ListContainers(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	byService := make(map[string]ContainerInfo)
+	for _, cont := range containers {
+		byService[cont.Service] = cont
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]ServiceExecResult, len(services))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, svc := range services {
+		cont, ok := byService[svc]
+		if !ok {
+			results[i] = ServiceExecResult{Service: svc, State: "missing", ExitCode: -1, Error: "no container"}
+			continue
+		}
+		if cont.State != "running" {
+			results[i] = ServiceExecResult{Service: svc, Container: cont.Name, State: cont.State, ExitCode: -1, Error: "not running"}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, svc string, cont ContainerInfo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			execCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			res, err := c.ContainerExec(execCtx, cont.ID, cmd)
+			r := ServiceExecResult{
+				Service:   svc,
+				Container: cont.Name,
+				State:     cont.State,
+				Duration:  time.Since(start),
+			}
+			if err != nil {
+				r.ExitCode = -1
+				r.Error = err.Error()
+			} else {
+				r.Stdout = res.Stdout
+				r.Stderr = res.Stderr
+				r.ExitCode = res.ExitCode
+			}
+			results[i] = r
+		}(i, svc, cont)
+	}
+
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Service < results[j].Service
+	})
+
+	return results, nil
+}
@@ -0,0 +1,204 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigHashLabel is the label compose sets to the hash of a service's configuration
+const ConfigHashLabel = "com.docker.compose.config-hash"
+
+// Project is the subset of the rendered compose model used by the CLI
+type Project struct {
+	Name     string                   `json:"name"`
+	Services map[string]ServiceConfig `json:"services"`
+}
+
+// ServiceConfig is a single service in the rendered compose model
+type ServiceConfig struct {
+	Image         string                `json:"image"`
+	ContainerName string                `json:"container_name"`
+	NetworkMode   string                `json:"network_mode"`
+	User          string                `json:"user"`
+	Environment   map[string]string     `json:"environment"`
+	Labels        map[string]string     `json:"labels"`
+	DependsOn     map[string]Dependency `json:"depends_on"`
+	Volumes       []ServiceVolume       `json:"volumes"`
+}
+
+// RunsAs returns the uid:gid the service's processes run as: its user, or
+// the PUID and PGID that linuxserver.io images switch to. It is empty when
+// the image decides, which is usually root.
+func (s ServiceConfig) RunsAs() string {
+	if s.User != "" {
+		return s.User
+	}
+	if puid := s.Environment["PUID"]; puid != "" {
+		if pgid := s.Environment["PGID"]; pgid != "" {
+			return puid + ":" + pgid
+		}
+		return puid
+	}
+	return ""
+}
+
+// ServiceVolume is a volume or bind mount of a service
+type ServiceVolume struct {
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// Dependency is a depends_on entry of a service
+type Dependency struct {
+	Condition string `json:"condition"`
+	Restart   bool   `json:"restart"`
+}
+
+// ServiceNames returns the project's service names in sorted order
+func (p *Project) ServiceNames() []string {
+	names := make([]string, 0, len(p.Services))
+	for name := range p.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Images returns the images of the project's services, in sorted order
+func (p *Project) Images() []string {
+	seen := make(map[string]bool)
+	images := make([]string, 0, len(p.Services))
+	for _, svc := range p.Services {
+		if svc.Image != "" && !seen[svc.Image] {
+			seen[svc.Image] = true
+			images = append(images, svc.Image)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// Dependents returns the services that depend on service, in sorted order
+func (p *Project) Dependents(service string) []string {
+	var dependents []string
+	for _, name := range p.ServiceNames() {
+		if _, ok := p.Services[name].DependsOn[service]; ok {
+			dependents = append(dependents, name)
+		}
+	}
+	return dependents
+}
+
+// ServicesMounting returns the services with a bind mount of path or of a
+// directory inside it, in sorted order
+func (p *Project) ServicesMounting(path string) []string {
+	path = filepath.Clean(path)
+	var services []string
+	for _, name := range p.ServiceNames() {
+		for _, v := range p.Services[name].Volumes {
+			if v.Type != "bind" {
+				continue
+			}
+			source := filepath.Clean(v.Source)
+			if source == path || strings.HasPrefix(source, path+string(filepath.Separator)) {
+				services = append(services, name)
+				break
+			}
+		}
+	}
+	return services
+}
+
+// StopOrder returns services together with every service that depends on
+// them, directly or not, ordered so that each service comes before the
+// services it depends on. Starting them in reverse order brings
+// dependencies up first.
+func (p *Project) StopOrder(services []string) []string {
+	include := make(map[string]bool)
+	var add func(string)
+	add = func(name string) {
+		if include[name] {
+			return
+		}
+		include[name] = true
+		for _, dependent := range p.Dependents(name) {
+			add(dependent)
+		}
+	}
+	for _, name := range services {
+		add(name)
+	}
+
+	var order []string
+	visited := make(map[string]bool)
+	var visit func(string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, dependent := range p.Dependents(name) {
+			if include[dependent] {
+				visit(dependent)
+			}
+		}
+		order = append(order, name)
+	}
+	for _, name := range p.ServiceNames() {
+		if include[name] {
+			visit(name)
+		}
+	}
+
+	// visit appends a service after its dependents, which is already stop
+	// order; services that only exist in the input are kept as given
+	for _, name := range services {
+		if _, ok := p.Services[name]; !ok {
+			order = append(order, name)
+		}
+	}
+	return order
+}
+
+// Model returns the fully rendered compose model with .env interpolation applied
+func (c *Compose) Model(ctx context.Context) (*Project, error) {
+	output, err := c.runCommandStdout(ctx, []string{"config", "--format", "json"})
+	if err != nil {
+		return nil, err
+	}
+
+	var project Project
+	if err := json.Unmarshal([]byte(output), &project); err != nil {
+		return nil, fmt.Errorf("failed to parse compose model: %w", err)
+	}
+
+	return &project, nil
+}
+
+// ConfigHashes returns the configuration hash compose computes for each
+// service. A service is recreated by compose when this differs from the
+// config-hash label on its container.
+func (c *Compose) ConfigHashes(ctx context.Context) (map[string]string, error) {
+	output, err := c.runCommandStdout(ctx, []string{"config", "--hash", "*"})
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			hashes[fields[0]] = fields[1]
+		}
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,303 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+)
+
+// PruneCandidate is a resource that a prune would remove
+type PruneCandidate struct {
+	Kind string `json:"kind"` // container, volume, network or image
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"` // -1 when unknown
+}
+
+// PruneReport summarises a prune operation
+type PruneReport struct {
+	Kind           string
+	Deleted        []string
+	SpaceReclaimed uint64
+}
+
+// pruneFilters returns filters limiting a prune to the project's resources.
+// Global prunes are unfiltered and affect every project on the host.
+func (c *Client) pruneFilters(global bool) filters.Args {
+	args := filters.NewArgs()
+	if !global && c.projectName != "" {
+		args.Add("label", fmt.Sprintf("%s=%s", ProjectLabel, c.projectName))
+	}
+	return args
+}
+
+// PruneContainers removes stopped containers belonging to the project, or
+// all stopped containers when global is set
+func (c *Client) PruneContainers(ctx context.Context, global bool) (PruneReport, error) {
+	report, err := c.cli.ContainersPrune(ctx, c.pruneFilters(global))
+	return PruneReport{
+		Kind:           "container",
+		Deleted:        report.ContainersDeleted,
+		SpaceReclaimed: report.SpaceReclaimed,
+	}, err
+}
+
+// PruneVolumes removes unused anonymous volumes belonging to the project, or
+// all unused anonymous volumes when global is set
+func (c *Client) PruneVolumes(ctx context.Context, global bool) (PruneReport, error) {
+	report, err := c.cli.VolumesPrune(ctx, c.pruneFilters(global))
+	return PruneReport{
+		Kind:           "volume",
+		Deleted:        report.VolumesDeleted,
+		SpaceReclaimed: report.SpaceReclaimed,
+	}, err
+}
+
+// PruneNetworks removes unused networks belonging to the project, or all
+// unused networks when global is set
+func (c *Client) PruneNetworks(ctx context.Context, global bool) (PruneReport, error) {
+	report, err := c.cli.NetworksPrune(ctx, c.pruneFilters(global))
+	return PruneReport{
+		Kind:    "network",
+		Deleted: report.NetworksDeleted,
+	}, err
+}
+
+// PruneImages removes images that no container uses. Without global, only
+// unused images of the project's service repositories, given as images,
+// are removed.
+func (c *Client) PruneImages(ctx context.Context, images []string, global bool) (PruneReport, error) {
+	if global {
+		report, err := c.cli.ImagesPrune(ctx, filters.NewArgs(filters.Arg("dangling", "false")))
+		deleted := make([]string, 0, len(report.ImagesDeleted))
+		for _, d := range report.ImagesDeleted {
+			if d.Deleted != "" {
+				deleted = append(deleted, d.Deleted)
+			}
+		}
+		return PruneReport{
+			Kind:           "image",
+			Deleted:        deleted,
+			SpaceReclaimed: report.SpaceReclaimed,
+		}, err
+	}
+
+	report := PruneReport{Kind: "image"}
+	unused, err := c.unusedImages(ctx, images, false)
+	if err != nil {
+		return report, err
+	}
+
+	var errs []error
+	for _, img := range unused {
+		// Forced, since an image with several tags cannot be removed by
+		// ID otherwise; unusedImages only returns images whose every tag
+		// belongs to the project
+		_, err := c.cli.ImageRemove(ctx, img.ID, image.RemoveOptions{Force: true, PruneChildren: true})
+		if errdefs.IsConflict(err) || errdefs.IsNotFound(err) {
+			// Taken into use or removed since it was listed
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report.Deleted = append(report.Deleted, img.ID)
+		report.SpaceReclaimed += uint64(img.Size)
+	}
+	return report, errors.Join(errs...)
+}
+
+// unusedImages lists the images that no container, running or stopped,
+// uses. Unless global is set, only images whose tags all belong to the
+// repositories of images are listed.
+func (c *Client) unusedImages(ctx context.Context, images []string, global bool) ([]image.Summary, error) {
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	used := make(map[string]bool, len(containers))
+	for _, cont := range containers {
+		used[cont.ImageID] = true
+	}
+
+	list, err := c.cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	repos := make(map[string]bool, len(images))
+	for _, ref := range images {
+		if name := repositoryName(ref); name != "" {
+			repos[name] = true
+		}
+	}
+
+	var unused []image.Summary
+	for _, img := range list {
+		if used[img.ID] || (!global && !inRepositories(img, repos)) {
+			continue
+		}
+		unused = append(unused, img)
+	}
+	return unused, nil
+}
+
+// inRepositories reports whether img has at least one tag or digest and all
+// of them belong to repos. Untagged images are matched by their digests,
+// which they keep after a pull moves their tag to a newer image.
+func inRepositories(img image.Summary, repos map[string]bool) bool {
+	found := false
+	for _, ref := range append(append([]string{}, img.RepoTags...), img.RepoDigests...) {
+		name := repositoryName(ref)
+		if name == "" {
+			continue
+		}
+		if !repos[name] {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// repositoryName returns the normalized repository of an image reference,
+// e.g. docker.io/library/nginx for nginx:latest, or "" for <none>:<none>
+func repositoryName(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	return named.Name()
+}
+
+// imageName returns a readable name for an image
+func imageName(img image.Summary) string {
+	for _, tag := range img.RepoTags {
+		if tag != "<none>:<none>" {
+			return tag
+		}
+	}
+	for _, digest := range img.RepoDigests {
+		if named, err := reference.ParseNormalizedNamed(digest); err == nil {
+			return reference.FamiliarName(named) + ":<none>"
+		}
+	}
+	return "<none>"
+}
+
+// PruneCandidates lists the containers, volumes and networks that the
+// Prune* methods would remove, without removing anything. Images are
+// listed as PruneImages would remove them for the service images in
+// images; a nil images leaves them out.
+func (c *Client) PruneCandidates(ctx context.Context, global bool, images []string) ([]PruneCandidate, error) {
+	var candidates []PruneCandidate
+
+	// Stopped containers
+	containerFilters := c.pruneFilters(global)
+	for _, status := range []string{"created", "exited", "dead"} {
+		containerFilters.Add("status", status)
+	}
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Size:    true,
+		Filters: containerFilters,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	for _, cont := range containers {
+		name := cont.ID[:12]
+		if len(cont.Names) > 0 {
+			name = strings.TrimPrefix(cont.Names[0], "/")
+		}
+		candidates = append(candidates, PruneCandidate{
+			Kind: "container",
+			ID:   cont.ID[:12],
+			Name: name,
+			Size: cont.SizeRw,
+		})
+	}
+
+	// Unused volumes, with sizes from the disk usage report
+	volumeFilters := c.pruneFilters(global)
+	volumeFilters.Add("dangling", "true")
+	volumes, err := c.cli.VolumeList(ctx, volume.ListOptions{Filters: volumeFilters})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	if len(volumes.Volumes) > 0 {
+		sizes := make(map[string]int64)
+		if du, err := c.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}}); err == nil {
+			for _, v := range du.Volumes {
+				if v.UsageData != nil {
+					sizes[v.Name] = v.UsageData.Size
+				}
+			}
+		}
+		for _, v := range volumes.Volumes {
+			// Prune only removes anonymous volumes, never named ones
+			if _, anonymous := v.Labels["com.docker.volume.anonymous"]; !anonymous {
+				continue
+			}
+			size, ok := sizes[v.Name]
+			if !ok {
+				size = -1
+			}
+			candidates = append(candidates, PruneCandidate{
+				Kind: "volume",
+				ID:   v.Name,
+				Name: v.Name,
+				Size: size,
+			})
+		}
+	}
+
+	// Unused networks
+	networkFilters := c.pruneFilters(global)
+	networkFilters.Add("dangling", "true")
+	networks, err := c.cli.NetworkList(ctx, network.ListOptions{Filters: networkFilters})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, n := range networks {
+		candidates = append(candidates, PruneCandidate{
+			Kind: "network",
+			ID:   n.ID[:12],
+			Name: n.Name,
+			Size: -1,
+		})
+	}
+
+	// Unused images
+	if images != nil {
+		unused, err := c.unusedImages(ctx, images, global)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range unused {
+			id := strings.TrimPrefix(img.ID, "sha256:")
+			if len(id) > 12 {
+				id = id[:12]
+			}
+			candidates = append(candidates, PruneCandidate{
+				Kind: "image",
+				ID:   id,
+				Name: imageName(img),
+				Size: img.Size,
+			})
+		}
+	}
+
+	return candidates, nil
+}
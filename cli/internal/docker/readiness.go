@@ -0,0 +1,398 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Probe is a network check that a service is accepting requests
+type Probe struct {
+	Kind   string `json:"kind"` // http or tcp
+	Scheme string `json:"scheme,omitempty"`
+	Port   int    `json:"port"`
+}
+
+func (p Probe) String() string {
+	if p.Kind == "http" {
+		return fmt.Sprintf("%s:%d", p.Scheme, p.Port)
+	}
+	return fmt.Sprintf("tcp:%d", p.Port)
+}
+
+// ServiceProbes derives probes from a service's Traefik service port
+// labels. Services without traefik.enable=true have no probes.
+func ServiceProbes(svc ServiceConfig) []Probe {
+	if svc.Labels["traefik.enable"] != "true" {
+		return nil
+	}
+
+	var probes []Probe
+	seen := make(map[string]bool)
+	for key, value := range svc.Labels {
+		parts := strings.Split(key, ".")
+		// traefik.<proto>.services.<name>.loadbalancer.server.port
+		if len(parts) != 7 || parts[0] != "traefik" || parts[2] != "services" ||
+			parts[4] != "loadbalancer" || parts[5] != "server" || parts[6] != "port" {
+			continue
+		}
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+
+		probe := Probe{Kind: "tcp", Port: port}
+		if parts[1] == "http" {
+			probe.Kind = "http"
+			probe.Scheme = "http"
+			if scheme := svc.Labels[fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.scheme", parts[3])]; scheme == "https" {
+				probe.Scheme = scheme
+			}
+		}
+		if !seen[probe.String()] {
+			seen[probe.String()] = true
+			probes = append(probes, probe)
+		}
+	}
+
+	sort.Slice(probes, func(i, j int) bool { return probes[i].String() < probes[j].String() })
+	return probes
+}
+
+// ReadinessOptions controls VerifyReadiness
+type ReadinessOptions struct {
+	Timeout      time.Duration // overall deadline for every service to become ready
+	Interval     time.Duration // delay between polls
+	ProbeTimeout time.Duration // deadline for a single probe
+	LogLines     int           // log lines to collect for services that never became ready
+	SkipProbes   string        // why probes cannot run, from ProbesReachable; empty runs them
+}
+
+// ServiceReadiness is the final readiness state of a service
+type ServiceReadiness struct {
+	Service  string        `json:"service"`
+	Ready    bool          `json:"ready"`
+	State    string        `json:"state"`
+	Health   string        `json:"health,omitempty"`
+	Probes   []string      `json:"probes,omitempty"`
+	Reason   string        `json:"reason,omitempty"`
+	ExitCode int           `json:"exit_code,omitempty"`
+	Logs     []string      `json:"logs,omitempty"`
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// VerifyReadiness polls Docker health and the services' probes until every
+// service is ready or the timeout passes. Results are in service order.
+func (c *Client) VerifyReadiness(ctx context.Context, project *Project, services []string, opts ReadinessOptions) []ServiceReadiness {
+	start := time.Now()
+	deadline := start.Add(opts.Timeout)
+
+	results := make(map[string]*ServiceReadiness, len(services))
+	for _, s := range services {
+		results[s] = &ServiceReadiness{Service: s}
+	}
+
+	httpClient := &http.Client{
+		Timeout: opts.ProbeTimeout,
+		Transport: &http.Transport{
+			// Services behind Traefik commonly use self-signed certificates
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		// Any response, including a redirect to a login page, means the
+		// service is serving requests
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	var byService map[string]string
+	for {
+		pending := make([]string, 0, len(services))
+		for _, s := range services {
+			if !results[s].Ready {
+				pending = append(pending, s)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		var err error
+		byService, err = c.serviceContainers(ctx)
+		if err != nil {
+			for _, s := range pending {
+				results[s].Reason = err.Error()
+			}
+		} else {
+			var wg sync.WaitGroup
+			sem := make(chan struct{}, inspectConcurrency)
+			for _, s := range pending {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(r *ServiceReadiness) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					c.checkReadiness(ctx, httpClient, project, byService, opts.SkipProbes, r)
+					if r.Ready {
+						r.Elapsed = time.Since(start).Round(time.Millisecond)
+					}
+				}(results[s])
+			}
+			wg.Wait()
+		}
+
+		if ctx.Err() != nil || time.Now().Add(opts.Interval).After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(opts.Interval):
+		}
+	}
+
+	list := make([]ServiceReadiness, 0, len(services))
+	for _, s := range services {
+		r := results[s]
+		if !r.Ready {
+			r.Elapsed = time.Since(start).Round(time.Millisecond)
+			if id, ok := byService[s]; ok && opts.LogLines > 0 {
+				r.Logs = c.tailLogs(ctx, id, opts.LogLines)
+			}
+		}
+		list = append(list, *r)
+	}
+	return list
+}
+
+// serviceContainers maps each service to its container ID, including
+// stopped containers
+func (c *Client) serviceContainers(ctx context.Context) (map[string]string, error) {
+	list, err := c.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", ProjectLabel, c.projectName))),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	byService := make(map[string]string, len(list))
+	for _, cont := range list {
+		if service := cont.Labels[ServiceLabel]; service != "" {
+			byService[service] = cont.ID
+		}
+	}
+	return byService, nil
+}
+
+// ProbesReachable reports whether container IP addresses can be dialled
+// from this host, and why not when they cannot: the daemon is remote, or
+// runs in a VM as with Docker Desktop. A CLI running in a container on the
+// stack's network reaches them either way.
+func (c *Client) ProbesReachable(ctx context.Context) (bool, string) {
+	if IsRunningInDocker() {
+		return true, ""
+	}
+	if runtime.GOOS != "linux" {
+		return false, "the Docker daemon runs in a VM on " + runtime.GOOS
+	}
+
+	if u, err := url.Parse(c.cli.DaemonHost()); err == nil && u.Scheme != "unix" && u.Scheme != "npipe" {
+		host := u.Hostname()
+		ip := net.ParseIP(host)
+		if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return false, "the Docker daemon is remote (" + u.Host + ")"
+		}
+	}
+
+	if info, err := c.cli.Info(ctx); err == nil && strings.Contains(info.OperatingSystem, "Docker Desktop") {
+		return false, "the Docker daemon runs in the Docker Desktop VM"
+	}
+	return true, ""
+}
+
+// checkReadiness updates r with the current state of its service. When
+// skipProbes is set the service's probes are not run.
+func (c *Client) checkReadiness(ctx context.Context, httpClient *http.Client, project *Project, byService map[string]string, skipProbes string, r *ServiceReadiness) {
+	r.Probes = nil
+	id, ok := byService[r.Service]
+	if !ok {
+		r.State = "missing"
+		r.Reason = "container not found"
+		return
+	}
+
+	inspect, err := c.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		r.Reason = fmt.Sprintf("inspect failed: %v", err)
+		return
+	}
+
+	r.State = inspect.State.Status
+	r.ExitCode = inspect.State.ExitCode
+	r.Health = ""
+	if inspect.State.Health != nil {
+		r.Health = inspect.State.Health.Status
+	}
+
+	switch {
+	case inspect.State.Restarting:
+		r.Reason = fmt.Sprintf("restarting (last exit code %d)", inspect.State.ExitCode)
+		return
+	case !inspect.State.Running:
+		r.Reason = fmt.Sprintf("%s with exit code %d", inspect.State.Status, inspect.State.ExitCode)
+		if inspect.State.Error != "" {
+			r.Reason += ": " + inspect.State.Error
+		}
+		return
+	case r.Health == "starting":
+		r.Reason = "health check starting"
+		return
+	case r.Health == "unhealthy":
+		r.Reason = "unhealthy"
+		if n := len(inspect.State.Health.Log); n > 0 {
+			r.Reason += ": " + strings.TrimSpace(inspect.State.Health.Log[n-1].Output)
+		}
+		return
+	}
+
+	probes := ServiceProbes(project.Services[r.Service])
+	if skipProbes != "" {
+		for _, p := range probes {
+			r.Probes = append(r.Probes, p.String()+" skipped")
+		}
+	} else if len(probes) > 0 {
+		host, err := c.probeHost(ctx, project, r.Service, inspect)
+		if err != nil {
+			r.Reason = err.Error()
+			return
+		}
+		for _, p := range probes {
+			if err := runProbe(ctx, httpClient, host, p); err != nil {
+				r.Probes = append(r.Probes, p.String()+" failed")
+				r.Reason = err.Error()
+				return
+			}
+			r.Probes = append(r.Probes, p.String()+" ok")
+		}
+	}
+
+	r.Ready = true
+	r.Reason = ""
+	r.ExitCode = 0
+}
+
+// probeHost returns the address a service's ports are reachable on. Services
+// sharing another service's network namespace (network_mode: service:x) are
+// probed through that service's container.
+func (c *Client) probeHost(ctx context.Context, project *Project, service string, inspect types.ContainerJSON) (string, error) {
+	mode := project.Services[service].NetworkMode
+	switch {
+	case mode == "host":
+		return "127.0.0.1", nil
+	case strings.HasPrefix(mode, "service:"):
+		byService, err := c.serviceContainers(ctx)
+		if err != nil {
+			return "", err
+		}
+		target := strings.TrimPrefix(mode, "service:")
+		id, ok := byService[target]
+		if !ok {
+			return "", fmt.Errorf("network service %s not found", target)
+		}
+		if inspect, err = c.cli.ContainerInspect(ctx, id); err != nil {
+			return "", fmt.Errorf("inspect %s failed: %w", target, err)
+		}
+	}
+
+	if inspect.NetworkSettings != nil {
+		names := make([]string, 0, len(inspect.NetworkSettings.Networks))
+		for name := range inspect.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if ip := inspect.NetworkSettings.Networks[name].IPAddress; ip != "" {
+				return ip, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no container IP address")
+}
+
+// runProbe performs a single probe against host
+func runProbe(ctx context.Context, httpClient *http.Client, host string, p Probe) error {
+	addr := net.JoinHostPort(host, strconv.Itoa(p.Port))
+
+	if p.Kind == "tcp" {
+		dialer := net.Dialer{Timeout: httpClient.Timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return fmt.Errorf("tcp %d: %w", p.Port, err)
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Scheme+"://"+addr+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %d: %w", p.Scheme, p.Port, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s %d: %s", p.Scheme, p.Port, resp.Status)
+	}
+	return nil
+}
+
+// tailLogs returns the last lines of a container's combined output
+func (c *Client) tailLogs(ctx context.Context, id string, lines int) []string {
+	inspect, err := c.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil
+	}
+
+	out, err := c.cli.ContainerLogs(ctx, id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(lines),
+	})
+	if err != nil {
+		return nil
+	}
+	defer out.Close()
+
+	var buf bytes.Buffer
+	if inspect.Config != nil && inspect.Config.Tty {
+		_, err = buf.ReadFrom(out)
+	} else {
+		_, err = stdcopy.StdCopy(&buf, &buf, out)
+	}
+	if err != nil && buf.Len() == 0 {
+		return nil
+	}
+
+	var result []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		result = append(result, scanner.Text())
+	}
+	return result
+}
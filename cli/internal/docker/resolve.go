@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ServiceAliases maps common alternative names to compose service names
+var ServiceAliases = map[string]string{
+	"postgres":         "postgresql",
+	"pg":               "postgresql",
+	"redis":            "valkey",
+	"authentik-worker": "authentic-worker",
+	"worker":           "authentic-worker",
+	"certs-dumper":     "traefik-certs-dumper",
+	"vpn":              "gluetun",
+	"qbit":             "qbittorrent",
+	"qb":               "qbittorrent",
+	"sab":              "sabnzbd",
+	"seerr":            "jellyseerr",
+	"ddns":             "ddns-updater",
+	"tdarr-server":     "tdarr",
+}
+
+// maxSuggestions is the number of close matches listed when a service is unknown
+const maxSuggestions = 3
+
+// ServiceNotFoundError is returned when a name does not match any service
+type ServiceNotFoundError struct {
+	Name        string
+	Suggestions []string
+}
+
+func (e *ServiceNotFoundError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("unknown service: %s", e.Name)
+	}
+	return fmt.Sprintf("unknown service: %s (did you mean: %s?)", e.Name, strings.Join(e.Suggestions, ", "))
+}
+
+// ResolveService matches name exactly against the known services, falling
+// back to ServiceAliases. When nothing matches, the error lists the closest
+// service names.
+func ResolveService(name string, services []string) (string, error) {
+	known := make(map[string]bool, len(services))
+	for _, svc := range services {
+		known[svc] = true
+	}
+
+	lower := strings.ToLower(strings.TrimSpace(name))
+	if known[lower] {
+		return lower, nil
+	}
+	if target, ok := ServiceAliases[lower]; ok && known[target] {
+		return target, nil
+	}
+
+	return "", &ServiceNotFoundError{
+		Name:        name,
+		Suggestions: closestServices(lower, services),
+	}
+}
+
+// ResolveServices resolves every name, failing on the first unknown one.
+// Duplicates are removed while preserving order.
+func ResolveServices(names, services []string) ([]string, error) {
+	resolved := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		svc, err := ResolveService(name, services)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[svc] {
+			seen[svc] = true
+			resolved = append(resolved, svc)
+		}
+	}
+
+	return resolved, nil
+}
+
+// ResolveServices validates service names against the compose configuration
+func (c *Compose) ResolveServices(ctx context.Context, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	services, err := c.ConfigServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	return ResolveServices(names, services)
+}
+
+// closestServices returns the services most similar to name
+func closestServices(name string, services []string) []string {
+	type candidate struct {
+		name  string
+		score int
+	}
+
+	var candidates []candidate
+	for _, svc := range services {
+		score := levenshtein(name, svc)
+		// Substring matches are likely what the user meant
+		if strings.Contains(svc, name) || strings.Contains(name, svc) {
+			score -= len(name)
+		}
+		if score <= len(name)/2+2 {
+			candidates = append(candidates, candidate{svc, score})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score < candidates[j].score
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var result []string
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		result = append(result, candidates[i].name)
+	}
+	return result
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,304 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ContainerStats holds a resource usage sample for a container
+type ContainerStats struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Service    string  `json:"service"`
+	CPUPercent float64 `json:"cpu_percent"`
+	MemUsage   uint64  `json:"mem_usage"`
+	MemLimit   uint64  `json:"mem_limit"`
+	MemPercent float64 `json:"mem_percent"`
+	NetRx      uint64  `json:"net_rx"`
+	NetTx      uint64  `json:"net_tx"`
+	BlockRead  uint64  `json:"block_read"`
+	BlockWrite uint64  `json:"block_write"`
+	PIDs       uint64  `json:"pids"`
+	Error      string  `json:"error,omitempty"` // Set when the stats could not be read
+}
+
+// statsFirstSampleWait bounds how long StatsMonitor.Sample waits for newly
+// opened streams to deliver their first sample
+const statsFirstSampleWait = 3 * time.Second
+
+// GetContainerStats takes a single resource usage sample for a container
+func (c *Client) GetContainerStats(ctx context.Context, containerID string) (*ContainerStats, error) {
+	resp, err := c.cli.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var raw container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode stats: %w", err)
+	}
+
+	return convertStats(containerID, &raw), nil
+}
+
+// convertStats turns a Docker stats response into a ContainerStats sample
+func convertStats(containerID string, raw *container.StatsResponse) *ContainerStats {
+	stats := &ContainerStats{
+		ID:         containerID,
+		Name:       strings.TrimPrefix(raw.Name, "/"),
+		CPUPercent: calculateCPUPercent(raw.Stats),
+		MemUsage:   calculateMemUsage(raw.MemoryStats),
+		MemLimit:   raw.MemoryStats.Limit,
+		PIDs:       raw.PidsStats.Current,
+	}
+	if stats.MemLimit > 0 {
+		stats.MemPercent = float64(stats.MemUsage) / float64(stats.MemLimit) * 100
+	}
+
+	for _, n := range raw.Networks {
+		stats.NetRx += n.RxBytes
+		stats.NetTx += n.TxBytes
+	}
+
+	for _, e := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			stats.BlockRead += e.Value
+		case "write":
+			stats.BlockWrite += e.Value
+		}
+	}
+
+	return stats
+}
+
+// ProjectStats samples resource usage for all running project containers.
+// Containers whose stats cannot be read are included with Error set.
+func (c *Client) ProjectStats(ctx context.Context) ([]ContainerStats, error) {
+	containers, err := c.ListContainers(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ContainerStats, len(containers))
+	sem := make(chan struct{}, inspectConcurrency)
+	var wg sync.WaitGroup
+
+	for i, cont := range containers {
+		wg.Add(1)
+		go func(i int, cont ContainerInfo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			stats, err := c.GetContainerStats(ctx, cont.ID)
+			if err != nil {
+				stats = &ContainerStats{Error: err.Error()}
+			}
+			stats.ID = cont.ID
+			stats.Name = cont.Name
+			stats.Service = cont.Service
+			results[i] = *stats
+		}(i, cont)
+	}
+
+	wg.Wait()
+
+	return results, nil
+}
+
+// StatsMonitor keeps a streaming stats reader open for each running project
+// container, so repeated samples return the latest figures at once instead
+// of waiting for a fresh one-shot sample from every container
+type StatsMonitor struct {
+	client *Client
+
+	mu      sync.Mutex
+	streams map[string]*statsStream
+}
+
+// statsStream is the stats reader of one container
+type statsStream struct {
+	cancel context.CancelFunc
+	first  chan struct{} // Closed once a sample or an error arrived
+	latest *ContainerStats
+	err    error
+	done   bool
+}
+
+// NewStatsMonitor returns a monitor for the project's containers. Close it
+// to stop the stats readers.
+func (c *Client) NewStatsMonitor() *StatsMonitor {
+	return &StatsMonitor{
+		client:  c,
+		streams: make(map[string]*statsStream),
+	}
+}
+
+// Sample returns the latest resource usage of all running project
+// containers. Readers are started for new containers and stopped for
+// removed ones; a new reader is waited for briefly so it can deliver its
+// first sample. Containers whose stats cannot be read are included with
+// Error set, and their reader is restarted on the next call.
+func (m *StatsMonitor) Sample(ctx context.Context) ([]ContainerStats, error) {
+	containers, err := m.client.ListContainers(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	running := make(map[string]bool, len(containers))
+	var started []*statsStream
+
+	m.mu.Lock()
+	for _, cont := range containers {
+		running[cont.ID] = true
+		if _, ok := m.streams[cont.ID]; !ok {
+			s := m.start(cont.ID)
+			m.streams[cont.ID] = s
+			started = append(started, s)
+		}
+	}
+	for id, s := range m.streams {
+		if !running[id] {
+			s.cancel()
+			delete(m.streams, id)
+		}
+	}
+	m.mu.Unlock()
+
+	timer := time.NewTimer(statsFirstSampleWait)
+	defer timer.Stop()
+wait:
+	for _, s := range started {
+		select {
+		case <-s.first:
+		case <-timer.C:
+			break wait
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]ContainerStats, 0, len(containers))
+	for _, cont := range containers {
+		s := m.streams[cont.ID]
+
+		stats := ContainerStats{Error: "no sample yet"}
+		switch {
+		case s.err != nil:
+			stats = ContainerStats{Error: s.err.Error()}
+		case s.latest != nil:
+			stats = *s.latest
+		}
+		stats.ID = cont.ID
+		stats.Name = cont.Name
+		stats.Service = cont.Service
+		results = append(results, stats)
+
+		if s.done {
+			// Reopen the stream on the next sample
+			delete(m.streams, cont.ID)
+		}
+	}
+
+	return results, nil
+}
+
+// start opens the stats stream of a container. m.mu must be held.
+func (m *StatsMonitor) start(containerID string) *statsStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &statsStream{cancel: cancel, first: make(chan struct{})}
+
+	go func() {
+		var firstOnce sync.Once
+		signalFirst := func() { firstOnce.Do(func() { close(s.first) }) }
+		defer signalFirst()
+
+		fail := func(err error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			s.err = err
+			s.done = true
+		}
+
+		resp, err := m.client.cli.ContainerStats(ctx, containerID, true)
+		if err != nil {
+			fail(fmt.Errorf("failed to get stats: %w", err))
+			return
+		}
+		defer resp.Body.Close()
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var raw container.StatsResponse
+			if err := dec.Decode(&raw); err != nil {
+				if err == io.EOF {
+					err = errors.New("stats stream ended")
+				} else {
+					err = fmt.Errorf("failed to decode stats: %w", err)
+				}
+				fail(err)
+				return
+			}
+
+			stats := convertStats(containerID, &raw)
+			m.mu.Lock()
+			s.latest = stats
+			m.mu.Unlock()
+			signalFirst()
+		}
+	}()
+
+	return s
+}
+
+// Close stops all stats readers
+func (m *StatsMonitor) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.streams {
+		s.cancel()
+		delete(m.streams, id)
+	}
+}
+
+// calculateCPUPercent computes CPU usage the same way as docker stats
+func calculateCPUPercent(s container.Stats) float64 {
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+
+	onlineCPUs := float64(s.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	if systemDelta <= 0 || cpuDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * onlineCPUs * 100
+}
+
+// calculateMemUsage returns memory usage excluding page cache, matching docker stats
+func calculateMemUsage(m container.MemoryStats) uint64 {
+	// cgroup v1
+	if v, ok := m.Stats["total_inactive_file"]; ok && v < m.Usage {
+		return m.Usage - v
+	}
+	// cgroup v2
+	if v, ok := m.Stats["inactive_file"]; ok && v < m.Usage {
+		return m.Usage - v
+	}
+	return m.Usage
+}
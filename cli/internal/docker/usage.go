@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ServiceDiskUsage is the Docker-managed disk usage of a service container
+type ServiceDiskUsage struct {
+	Service         string `json:"service"`
+	Container       string `json:"container"`
+	Image           string `json:"image"`
+	ImageSize       int64  `json:"image_size"`
+	ImageUniqueSize int64  `json:"image_unique_size"` // Layers not shared with other images
+	WritableSize    int64  `json:"writable_size"`
+	LogSize         int64  `json:"log_size"` // -1 when the log file is not readable
+}
+
+// Total returns the space attributable to the service, counting only image
+// layers that are not shared
+func (u ServiceDiskUsage) Total() int64 {
+	total := u.ImageUniqueSize + u.WritableSize
+	if u.LogSize > 0 {
+		total += u.LogSize
+	}
+	return total
+}
+
+// ProjectDiskUsage reports image, writable layer and log sizes for every
+// project container using Docker's system df data
+func (c *Client) ProjectDiskUsage(ctx context.Context) ([]ServiceDiskUsage, error) {
+	du, err := c.cli.DiskUsage(ctx, types.DiskUsageOptions{
+		Types: []types.DiskUsageObject{types.ContainerObject, types.ImageObject},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk usage: %w", err)
+	}
+
+	type imageSize struct{ size, shared int64 }
+	images := make(map[string]imageSize, len(du.Images))
+	for _, img := range du.Images {
+		shared := img.SharedSize
+		if shared < 0 {
+			shared = 0
+		}
+		images[img.ID] = imageSize{img.Size, shared}
+	}
+
+	var result []ServiceDiskUsage
+	for _, cont := range du.Containers {
+		if c.projectName != "" && cont.Labels[ProjectLabel] != c.projectName {
+			continue
+		}
+
+		name := cont.ID[:12]
+		if len(cont.Names) > 0 {
+			name = strings.TrimPrefix(cont.Names[0], "/")
+		}
+
+		img := images[cont.ImageID]
+		usage := ServiceDiskUsage{
+			Service:         cont.Labels[ServiceLabel],
+			Container:       name,
+			Image:           cont.Image,
+			ImageSize:       img.size,
+			ImageUniqueSize: img.size - img.shared,
+			WritableSize:    cont.SizeRw,
+			LogSize:         -1,
+		}
+		if usage.Service == "" {
+			usage.Service = name
+		}
+
+		// The log file lives on the Docker host; it is only readable when
+		// running there with sufficient privileges
+		if inspect, err := c.cli.ContainerInspect(ctx, cont.ID); err == nil && inspect.LogPath != "" {
+			if info, err := os.Stat(inspect.LogPath); err == nil {
+				usage.LogSize = info.Size()
+			}
+		}
+
+		result = append(result, usage)
+	}
+
+	return result, nil
+}
@@ -103,7 +103,7 @@ func newHelpModel(commands map[string]*Command) helpModel {
 		{"Stack Management", []string{"deploy", "stop", "restart", "pull"}},
 		{"Monitoring", []string{"status", "logs", "services"}},
 		{"Configuration", []string{"config", "validate", "apikeys"}},
-		{"Shell", []string{"exec", "clear", "help", "quit"}},
+		{"Shell", []string{"exec", "cp", "clear", "help", "quit"}},
 	}
 
 	var items []list.Item
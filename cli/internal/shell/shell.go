@@ -3,6 +3,8 @@ package shell
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"sort"
 	"strings"
 	"time"
@@ -31,6 +33,7 @@ type Shell struct {
 	commands map[string]*Command
 	history  []string
 	histIdx  int
+	deploy   func(args []string) error
 }
 
 // New creates a new interactive shell
@@ -45,6 +48,12 @@ func New(cfg *config.Config) *Shell {
 	return s
 }
 
+// SetDeploy sets the function /deploy runs with its arguments, the same
+// deploy as the deploy command
+func (s *Shell) SetDeploy(deploy func(args []string) error) {
+	s.deploy = deploy
+}
+
 // registerCommands sets up all slash commands
 func (s *Shell) registerCommands() {
 	commands := []*Command{
@@ -66,7 +75,7 @@ func (s *Shell) registerCommands() {
 			Name:        "deploy",
 			Aliases:     []string{"up", "start"},
 			Description: "Deploy the media stack",
-			Usage:       "/deploy [--pull]",
+			Usage:       "/deploy [--pull] [--force] [deploy flags]",
 			Handler:     s.cmdDeploy,
 		},
 		{
@@ -132,6 +141,13 @@ func (s *Shell) registerCommands() {
 			Usage:       "/exec <service> <command>",
 			Handler:     s.cmdExec,
 		},
+		{
+			Name:        "cp",
+			Aliases:     []string{"copy"},
+			Description: "Copy files to or from a container",
+			Usage:       "/cp <src> <dst> [--chown]",
+			Handler:     s.cmdCp,
+		},
 		{
 			Name:        "clear",
 			Aliases:     []string{"cls"},
@@ -335,6 +351,112 @@ func (s *Shell) autocomplete(input string) string {
 	return input
 }
 
+// acquireLock takes the stack lock for a shell command. The returned
+// function releases it.
+func (s *Shell) acquireLock(name string, args []string) (func(), error) {
+	command := strings.TrimSpace("mediastack shell /" + name + " " + strings.Join(args, " "))
+	lock, err := stack.AcquireLock(s.cfg.ConfigDir, command)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := lock.Release(); err != nil {
+			ui.PrintError(err.Error())
+		}
+	}, nil
+}
+
+// hookSession returns a hook session for an operation, with hook output
+// going to stdout and the deploy log. The returned function closes the log.
+func (s *Shell) hookSession(operation string) (*stack.HookSession, func()) {
+	env := stack.HookEnv{
+		Operation: operation,
+		Variant:   s.cfg.Variant,
+		Project:   s.cfg.ProjectName,
+		ConfigDir: s.cfg.ConfigDir,
+	}
+
+	var output io.Writer = os.Stdout
+	closeLog := func() {}
+	if logFile, err := stack.OpenDeployLog(s.cfg.ConfigDir); err != nil {
+		ui.PrintError(fmt.Sprintf("Could not open deploy log: %v", err))
+	} else {
+		output = io.MultiWriter(os.Stdout, logFile)
+		closeLog = func() { logFile.Close() }
+	}
+
+	return stack.NewHookSession(env, stack.DefaultHookTimeout, output), closeLog
+}
+
+// recordHistory starts a journal entry for a shell operation, recording
+// the service images returned by images before and after it. The returned
+// function records the outcome and appends the entry.
+func (s *Shell) recordHistory(operation string, args []string, images func() map[string]string) (*stack.HistoryEntry, func(opErr error)) {
+	entry := stack.NewHistoryEntry(operation, s.cfg.Variant, args, nil)
+	entry.ImagesBefore = images()
+
+	return entry, func(opErr error) {
+		entry.Finish(opErr)
+		entry.ImagesAfter = images()
+		if err := stack.AppendHistory(s.cfg.ConfigDir, entry); err != nil {
+			ui.PrintError(fmt.Sprintf("Could not record history: %v", err))
+		}
+	}
+}
+
+// serviceImageIDs returns the container image ID of each service, or nil
+// if Docker cannot be reached
+func (s *Shell) serviceImageIDs() map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := docker.NewClient(s.cfg.ProjectName)
+	if err != nil {
+		return nil
+	}
+	defer client.Close()
+
+	images, err := client.ServiceImageIDs(ctx)
+	if err != nil {
+		return nil
+	}
+	return images
+}
+
+// pulledImageIDs returns the local image ID of each service's image
+// reference, or nil if Docker or the compose model cannot be reached
+func (s *Shell) pulledImageIDs() map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	compose := docker.NewCompose(s.cfg.ProjectName, s.cfg.ConfigDir, s.cfg.ComposeFile())
+	project, err := compose.Model(ctx)
+	if err != nil {
+		return nil
+	}
+
+	client, err := docker.NewClient(s.cfg.ProjectName)
+	if err != nil {
+		return nil
+	}
+	defer client.Close()
+
+	images, err := client.ImageRefIDs(ctx, project)
+	if err != nil {
+		return nil
+	}
+	return images
+}
+
+// finishHooks runs the post or on-failure hook, reporting but not
+// propagating hook failures
+func finishHooks(hooks *stack.HookSession, post stack.Hook, opErr error) {
+	if err := hooks.Finish(context.Background(), post, opErr); err != nil {
+		ui.PrintError(err.Error())
+	}
+}
+
 // Command handlers
 
 func (s *Shell) cmdHelp(args []string) error {
@@ -433,60 +555,48 @@ func (s *Shell) cmdStatus(args []string) error {
 }
 
 func (s *Shell) cmdDeploy(args []string) error {
-	pull := false
-	for _, arg := range args {
-		if arg == "--pull" || arg == "-p" {
-			pull = true
-		}
+	if s.deploy == nil {
+		return fmt.Errorf("deploy is not available")
 	}
-
 	ui.PrintCommand("Deploying media stack...")
+	return s.deploy(args)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
+func (s *Shell) cmdStop(args []string) (retErr error) {
+	ui.PrintCommand("Stopping media stack...")
 
-	// Create directories
-	ui.PrintInfo("Creating directories...")
-	if err := stack.CreateDirectories(s.cfg.DataFolder, s.cfg.MediaFolder, s.cfg.PUID, s.cfg.PGID, false, false); err != nil {
+	release, err := s.acquireLock("stop", args)
+	if err != nil {
 		return err
 	}
+	defer release()
 
-	// Copy config files
-	ui.PrintInfo("Copying configuration files...")
-	if err := stack.CopyConfigFiles(s.cfg.ConfigDir, s.cfg.DataFolder, s.cfg.PUID, s.cfg.PGID, false, false); err != nil {
-		return err
-	}
+	entry, finishHistory := s.recordHistory("stop", args, s.serviceImageIDs)
+	defer func() { finishHistory(retErr) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
 
 	compose := docker.NewCompose(s.cfg.ProjectName, s.cfg.ConfigDir, s.cfg.ComposeFile())
 
-	// Pull if requested
-	if pull {
-		ui.PrintInfo("Pulling images...")
-		if err := compose.Pull(ctx); err != nil {
-			return err
-		}
+	services, err := compose.ResolveServices(ctx, args)
+	if err != nil {
+		return err
 	}
+	entry.Services = services
+	entry.AllServices = len(services) == 0
 
-	// Start services
-	ui.PrintInfo("Starting services...")
-	if err := compose.Up(ctx, true, false); err != nil {
+	hooks, closeLog := s.hookSession("stop")
+	defer closeLog()
+	hooks.SetServices(services)
+	if err := hooks.Pre(ctx, stack.HookPreStop); err != nil {
+		finishHooks(hooks, stack.HookPostStop, err)
 		return err
 	}
+	defer func() { finishHooks(hooks, stack.HookPostStop, retErr) }()
 
-	ui.PrintSuccess("Deployment complete!")
-	return nil
-}
-
-func (s *Shell) cmdStop(args []string) error {
-	ui.PrintCommand("Stopping media stack...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
-
-	compose := docker.NewCompose(s.cfg.ProjectName, s.cfg.ConfigDir, s.cfg.ComposeFile())
-
-	if len(args) > 0 {
-		for _, service := range args {
+	if len(services) > 0 {
+		for _, service := range services {
 			ui.PrintInfo(fmt.Sprintf("Stopping %s...", service))
 			if err := compose.StopService(ctx, service); err != nil {
 				return err
@@ -502,16 +612,32 @@ func (s *Shell) cmdStop(args []string) error {
 	return nil
 }
 
-func (s *Shell) cmdRestart(args []string) error {
+func (s *Shell) cmdRestart(args []string) (retErr error) {
 	ui.PrintCommand("Restarting media stack...")
 
+	release, err := s.acquireLock("restart", args)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entry, finishHistory := s.recordHistory("restart", args, s.serviceImageIDs)
+	defer func() { finishHistory(retErr) }()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
 	compose := docker.NewCompose(s.cfg.ProjectName, s.cfg.ConfigDir, s.cfg.ComposeFile())
 
-	if len(args) > 0 {
-		for _, service := range args {
+	services, err := compose.ResolveServices(ctx, args)
+	if err != nil {
+		return err
+	}
+	entry.Services = services
+	entry.AllServices = len(services) == 0
+
+	if len(services) > 0 {
+		for _, service := range services {
 			ui.PrintInfo(fmt.Sprintf("Restarting %s...", service))
 			if err := compose.RestartService(ctx, service); err != nil {
 				return err
@@ -532,25 +658,46 @@ func (s *Shell) cmdLogs(args []string) error {
 		return fmt.Errorf("usage: /logs <service>")
 	}
 
-	service := args[0]
-	ui.PrintCommand(fmt.Sprintf("Showing logs for %s (Ctrl+C to stop)...", service))
-
 	ctx := context.Background()
 	compose := docker.NewCompose(s.cfg.ProjectName, s.cfg.ConfigDir, s.cfg.ComposeFile())
 
+	services, err := compose.ResolveServices(ctx, args[:1])
+	if err != nil {
+		return err
+	}
+
+	service := services[0]
+	ui.PrintCommand(fmt.Sprintf("Showing logs for %s (Ctrl+C to stop)...", service))
+
 	return compose.Logs(ctx, service, true, "50", false)
 }
 
-func (s *Shell) cmdPull(args []string) error {
+func (s *Shell) cmdPull(args []string) (retErr error) {
 	ui.PrintCommand("Pulling images...")
 
+	release, err := s.acquireLock("pull", args)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entry, finishHistory := s.recordHistory("pull", args, s.pulledImageIDs)
+	defer func() { finishHistory(retErr) }()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
 
 	compose := docker.NewCompose(s.cfg.ProjectName, s.cfg.ConfigDir, s.cfg.ComposeFile())
 
-	if len(args) > 0 {
-		for _, service := range args {
+	services, err := compose.ResolveServices(ctx, args)
+	if err != nil {
+		return err
+	}
+	entry.Services = services
+	entry.AllServices = len(services) == 0
+
+	if len(services) > 0 {
+		for _, service := range services {
 			ui.PrintInfo(fmt.Sprintf("Pulling %s...", service))
 			if err := compose.PullService(ctx, service); err != nil {
 				return err
@@ -638,17 +785,66 @@ func (s *Shell) cmdExec(args []string) error {
 		return fmt.Errorf("usage: /exec <service> <command>")
 	}
 
-	service := args[0]
 	command := args[1:]
 
-	ui.PrintCommand(fmt.Sprintf("Executing in %s: %s", service, strings.Join(command, " ")))
-
 	ctx := context.Background()
 	compose := docker.NewCompose(s.cfg.ProjectName, s.cfg.ConfigDir, s.cfg.ComposeFile())
 
+	services, err := compose.ResolveServices(ctx, args[:1])
+	if err != nil {
+		return err
+	}
+
+	service := services[0]
+	ui.PrintCommand(fmt.Sprintf("Executing in %s: %s", service, strings.Join(command, " ")))
+
 	return compose.Exec(ctx, service, command, true)
 }
 
+func (s *Shell) cmdCp(args []string) error {
+	chown := false
+	var paths []string
+	for _, arg := range args {
+		if arg == "--chown" {
+			chown = true
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) != 2 {
+		return fmt.Errorf("usage: /cp <src> <dst> [--chown]")
+	}
+
+	ui.PrintCommand(fmt.Sprintf("Copying %s -> %s...", paths[0], paths[1]))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	client, err := docker.NewClient(s.cfg.ProjectName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	opts := docker.CopyOptions{
+		Chown: chown,
+		UID:   s.cfg.PUID,
+		GID:   s.cfg.PGID,
+	}
+
+	stats, err := client.Copy(ctx, paths[0], paths[1], opts)
+	if err != nil {
+		return err
+	}
+	if stats.ChownFailed > 0 {
+		ui.PrintError(fmt.Sprintf("Could not set ownership of %d files", stats.ChownFailed))
+	}
+
+	ui.PrintSuccess("Copy complete!")
+	return nil
+}
+
 func (s *Shell) cmdClear(args []string) error {
 	fmt.Print("\033[H\033[2J")
 	return nil
@@ -8,55 +8,8 @@ import (
 	"github.com/fatih/color"
 )
 
-// DataDirectories are the directories needed in FOLDER_FOR_DATA
-var DataDirectories = []string{
-	"authentik/certs",
-	"authentik/media",
-	"authentik/templates",
-	"bazarr",
-	"chromium",
-	"crowdsec/data",
-	"ddns-updater",
-	"filebot",
-	"gluetun",
-	"grafana",
-	"headplane/data",
-	"headscale/data",
-	"heimdall",
-	"homarr/configs",
-	"homarr/data",
-	"homarr/icons",
-	"homepage",
-	"huntarr",
-	"jellyfin",
-	"jellyseerr",
-	"lidarr",
-	"logs/unpackerr",
-	"logs/traefik",
-	"mylar",
-	"plex",
-	"portainer",
-	"postgresql",
-	"prometheus",
-	"prowlarr",
-	"qbittorrent",
-	"radarr",
-	"readarr",
-	"sabnzbd",
-	"sonarr",
-	"tailscale",
-	"tdarr/server",
-	"tdarr/configs",
-	"tdarr/logs",
-	"tdarr-node",
-	"traefik/letsencrypt",
-	"traefik-certs-dumper",
-	"unpackerr",
-	"valkey",
-	"whisparr",
-}
-
-// MediaDirectories are the directories needed in FOLDER_FOR_MEDIA
+// MediaDirectories are the subfolders of FOLDER_FOR_MEDIA the apps expect
+// inside their mounts, created in addition to the mounted directories
 var MediaDirectories = []string{
 	// Media categories
 	"media/anime",
@@ -103,25 +56,25 @@ var MediaDirectories = []string{
 }
 
 // CreateDirectories creates all required directories with proper permissions
-func CreateDirectories(dataFolder, mediaFolder string, uid, gid int, verbose bool, dryRun bool) error {
+func CreateDirectories(dirs *Directories, uid, gid int, verbose bool, dryRun bool) error {
 	if verbose {
 		color.Cyan("Creating directories...")
-		color.Cyan("  Data folder: %s", dataFolder)
-		color.Cyan("  Media folder: %s", mediaFolder)
+		color.Cyan("  Data folder: %s", dirs.DataFolder)
+		color.Cyan("  Media folder: %s", dirs.MediaFolder)
 		color.Cyan("  UID:GID: %d:%d", uid, gid)
 	}
 
 	// Create data directories
-	for _, dir := range DataDirectories {
-		fullPath := filepath.Join(dataFolder, dir)
+	for _, dir := range dirs.Data {
+		fullPath := filepath.Join(dirs.DataFolder, dir)
 		if err := createDir(fullPath, uid, gid, verbose, dryRun); err != nil {
 			return fmt.Errorf("failed to create data directory %s: %w", dir, err)
 		}
 	}
 
 	// Create media directories
-	for _, dir := range MediaDirectories {
-		fullPath := filepath.Join(mediaFolder, dir)
+	for _, dir := range dirs.Media {
+		fullPath := filepath.Join(dirs.MediaFolder, dir)
 		if err := createDir(fullPath, uid, gid, verbose, dryRun); err != nil {
 			return fmt.Errorf("failed to create media directory %s: %w", dir, err)
 		}
@@ -167,77 +120,13 @@ func createDir(path string, uid, gid int, verbose bool, dryRun bool) error {
 	return nil
 }
 
-// SetPermissions recursively sets ownership and permissions on directories
-func SetPermissions(paths []string, uid, gid int, verbose bool, dryRun bool) error {
-	if verbose {
-		color.Cyan("Setting permissions...")
-	}
-
-	for _, path := range paths {
-		if dryRun {
-			if verbose {
-				fmt.Printf("  [dry-run] Would set permissions on: %s\n", path)
-			}
-			continue
-		}
-
-		// Walk the directory tree
-		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// Set ownership
-			if err := os.Chown(p, uid, gid); err != nil {
-				if verbose {
-					color.Yellow("  Warning: Could not set ownership on %s: %v", p, err)
-				}
-			}
-
-			// Set permissions based on type
-			var perm os.FileMode
-			if info.IsDir() {
-				perm = 02775 // rwxrwsr-x with setgid
-			} else {
-				perm = 0664 // rw-rw-r--
-			}
-
-			if err := os.Chmod(p, perm); err != nil {
-				if verbose {
-					color.Yellow("  Warning: Could not set permissions on %s: %v", p, err)
-				}
-			}
-
-			return nil
-		})
-
-		if err != nil {
-			return fmt.Errorf("failed to set permissions on %s: %w", path, err)
-		}
-	}
-
-	if verbose {
-		color.Green("Permissions set successfully")
-	}
-
-	return nil
-}
-
 // VerifyDirectories checks that all required directories exist
-func VerifyDirectories(dataFolder, mediaFolder string) []string {
+func VerifyDirectories(dirs *Directories) []string {
 	var missing []string
 
-	for _, dir := range DataDirectories {
-		fullPath := filepath.Join(dataFolder, dir)
-		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-			missing = append(missing, fullPath)
-		}
-	}
-
-	for _, dir := range MediaDirectories {
-		fullPath := filepath.Join(mediaFolder, dir)
-		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-			missing = append(missing, fullPath)
+	for _, path := range dirs.Paths() {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			missing = append(missing, path)
 		}
 	}
 
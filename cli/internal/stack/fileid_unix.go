@@ -0,0 +1,41 @@
+//go:build !windows
+
+package stack
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file on disk by device and inode
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// hardLinkID returns the identity of a file with more than one link
+func hardLinkID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink <= 1 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// fileOwner returns the owner of a file
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// deviceID returns the device a file is on
+func deviceID(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package stack
+
+import "os"
+
+// fileID identifies a file on disk by device and inode
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// hardLinkID is not supported on Windows; hard links are counted per path
+func hardLinkID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// fileOwner is not supported on Windows
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+// deviceID is not supported on Windows
+func deviceID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -1,10 +1,11 @@
 package stack
 
 import (
+	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/fatih/color"
 )
@@ -14,6 +15,7 @@ type ConfigFile struct {
 	Source      string // Filename in config directory
 	Destination string // Relative path in data folder
 	Permission  os.FileMode
+	Service     string // Service that reads the file
 }
 
 // ConfigFiles are the configuration files to copy during deployment
@@ -22,31 +24,37 @@ var ConfigFiles = []ConfigFile{
 		Source:      "headplane-config.yaml",
 		Destination: "headplane/config.yaml",
 		Permission:  0664,
+		Service:     "headplane",
 	},
 	{
 		Source:      "headscale-config.yaml",
 		Destination: "headscale/config.yaml",
 		Permission:  0664,
+		Service:     "headscale",
 	},
 	{
 		Source:      "traefik-static.yaml",
 		Destination: "traefik/traefik.yaml",
 		Permission:  0664,
+		Service:     "traefik",
 	},
 	{
 		Source:      "traefik-dynamic.yaml",
 		Destination: "traefik/dynamic.yaml",
 		Permission:  0664,
+		Service:     "traefik",
 	},
 	{
 		Source:      "traefik-internal.yaml",
 		Destination: "traefik/internal.yaml",
 		Permission:  0664,
+		Service:     "traefik",
 	},
 	{
 		Source:      "crowdsec-acquis.yaml",
 		Destination: "crowdsec/acquis.yaml",
 		Permission:  0664,
+		Service:     "crowdsec",
 	},
 }
 
@@ -63,14 +71,47 @@ var SpecialFiles = []struct {
 	},
 }
 
-// CopyConfigFiles copies all configuration files to their destinations
-func CopyConfigFiles(configDir, dataFolder string, uid, gid int, verbose bool, dryRun bool) error {
+// ConfigBackupDir is the directory in the config directory that holds
+// deployed config files backed up before being overwritten
+const ConfigBackupDir = "config-backups"
+
+// CopyConfigFiles renders all configuration files with the .env values in
+// env and writes them to their destinations. Nothing is written if any file
+// fails to render. A deployed file that differs from its rendered source is
+// backed up first, or left alone if its source name is listed in keepLocal.
+func CopyConfigFiles(configDir, dataFolder string, env map[string]string, keepLocal []string, uid, gid int, verbose bool, dryRun bool) error {
 	if verbose {
 		color.Cyan("Copying configuration files...")
 	}
 
+	keep := make(map[string]bool, len(keepLocal))
+	for _, name := range keepLocal {
+		keep[name] = true
+	}
+	stamp := time.Now().Format("20060102-150405")
+
+	// Render everything first so an unresolved variable leaves the
+	// deployed files untouched
+	rendered := make(map[string][]byte, len(ConfigFiles))
 	for _, cf := range ConfigFiles {
-		src := filepath.Join(configDir, cf.Source)
+		data, err := RenderConfigFile(configDir, cf, env)
+		if os.IsNotExist(err) {
+			if verbose {
+				color.Yellow("  Warning: Source file not found: %s", filepath.Join(configDir, cf.Source))
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", cf.Source, err)
+		}
+		rendered[cf.Source] = data
+	}
+
+	for _, cf := range ConfigFiles {
+		data, ok := rendered[cf.Source]
+		if !ok {
+			continue
+		}
 		dst := filepath.Join(dataFolder, cf.Destination)
 
 		if dryRun {
@@ -80,16 +121,28 @@ func CopyConfigFiles(configDir, dataFolder string, uid, gid int, verbose bool, d
 			continue
 		}
 
-		// Check if source exists
-		if _, err := os.Stat(src); os.IsNotExist(err) {
+		// Protect changes made to the deployed copy
+		existing, err := os.ReadFile(dst)
+		switch {
+		case err == nil && !bytes.Equal(existing, data):
 			if verbose {
-				color.Yellow("  Warning: Source file not found: %s", src)
+				PrintDiff(UnifiedDiff(string(existing), string(data), dst, cf.Source), "    ")
 			}
-			continue
+			if keep[cf.Source] {
+				color.Yellow("  Keeping local changes to %s (--keep-local)", dst)
+				continue
+			}
+			backup, err := backupConfigFile(configDir, stamp, cf, existing)
+			if err != nil {
+				return fmt.Errorf("failed to back up %s: %w", dst, err)
+			}
+			color.Yellow("  %s differed from %s, previous version saved to %s", dst, cf.Source, backup)
+		case err != nil && !os.IsNotExist(err):
+			return fmt.Errorf("failed to read %s: %w", dst, err)
 		}
 
-		// Copy the file
-		if err := copyFile(src, dst, cf.Permission); err != nil {
+		// Write the rendered file
+		if err := writeConfigFile(dst, data, cf.Permission); err != nil {
 			return fmt.Errorf("failed to copy %s: %w", cf.Source, err)
 		}
 
@@ -158,15 +211,21 @@ func CopyConfigFiles(configDir, dataFolder string, uid, gid int, verbose bool, d
 	return nil
 }
 
-// copyFile copies a file from src to dst with the specified permissions
-func copyFile(src, dst string, perm os.FileMode) error {
-	// Open source file
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("failed to open source: %w", err)
+// backupConfigFile saves the deployed contents of a config file under
+// config-backups/<stamp>/ in the config directory and returns the path
+func backupConfigFile(configDir, stamp string, cf ConfigFile, data []byte) (string, error) {
+	path := filepath.Join(configDir, ConfigBackupDir, stamp, filepath.FromSlash(cf.Destination))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
 	}
-	defer srcFile.Close()
+	if err := os.WriteFile(path, data, cf.Permission); err != nil {
+		return "", err
+	}
+	return path, nil
+}
 
+// writeConfigFile writes data to dst with the specified permissions
+func writeConfigFile(dst string, data []byte, perm os.FileMode) error {
 	// Ensure destination directory exists
 	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
@@ -177,14 +236,13 @@ func copyFile(src, dst string, perm os.FileMode) error {
 	if err != nil {
 		return fmt.Errorf("failed to create destination: %w", err)
 	}
-	defer dstFile.Close()
 
-	// Copy contents
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
-		return fmt.Errorf("failed to copy contents: %w", err)
+	if _, err := dstFile.Write(data); err != nil {
+		dstFile.Close()
+		return fmt.Errorf("failed to write contents: %w", err)
 	}
 
-	return nil
+	return dstFile.Close()
 }
 
 // SetConfigPermissions sets proper permissions on config files in the config directory
@@ -0,0 +1,147 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// DownloadClients maps each download client to the FOLDER_FOR_MEDIA
+// directory it downloads into
+var DownloadClients = map[string]string{
+	"qbittorrent": "torrents",
+	"sabnzbd":     "usenet",
+}
+
+// MediaManagers are the *arr apps that import downloads into media/
+var MediaManagers = []string{"lidarr", "mylar", "radarr", "readarr", "sonarr", "whisparr"}
+
+// HardlinkWarning is a reason a media manager will copy the downloads of a
+// client instead of hard-linking them
+type HardlinkWarning struct {
+	Client  string `json:"client,omitempty"`
+	Manager string `json:"manager,omitempty"`
+	Message string `json:"message"`
+}
+
+// CheckHardlinks checks that the download directories and media/ in
+// mediaFolder are on one filesystem, and that every media manager sees a
+// client's downloads and media/ through a single mount, at the path the
+// client reports them. mounts are the bind mounts of the services, e.g.
+// from the containers or the compose model.
+func CheckHardlinks(mediaFolder string, mounts []Mount) []HardlinkWarning {
+	var warnings []HardlinkWarning
+	media := filepath.Join(mediaFolder, "media")
+
+	// A hard link cannot cross filesystems
+	if mediaDev, ok := pathDevice(media); ok {
+		for _, dir := range downloadDirs() {
+			if dev, ok := pathDevice(filepath.Join(mediaFolder, dir)); ok && dev != mediaDev {
+				warnings = append(warnings, HardlinkWarning{
+					Message: fmt.Sprintf("%s/ and media/ in %s are on different filesystems; downloads will be copied, not hard-linked", dir, mediaFolder),
+				})
+			}
+		}
+	}
+
+	byService := make(map[string][]Mount)
+	for _, m := range mounts {
+		byService[m.Service] = append(byService[m.Service], m)
+	}
+
+	clients := make([]string, 0, len(DownloadClients))
+	for client := range DownloadClients {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+
+	for _, client := range clients {
+		dir := DownloadClients[client]
+		downloads := filepath.Join(mediaFolder, dir)
+		_, clientPath, ok := containerPath(byService[client], downloads)
+		if !ok {
+			continue
+		}
+
+		for _, manager := range MediaManagers {
+			if _, ok := byService[manager]; !ok {
+				continue
+			}
+			warn := func(format string, args ...any) {
+				warnings = append(warnings, HardlinkWarning{
+					Client:  client,
+					Manager: manager,
+					Message: fmt.Sprintf(format, args...),
+				})
+			}
+
+			dlMount, managerPath, ok := containerPath(byService[manager], downloads)
+			if !ok {
+				warn("%s does not mount %s/, where %s downloads to", manager, dir, client)
+				continue
+			}
+			mediaMount, _, ok := containerPath(byService[manager], media)
+			if !ok {
+				warn("%s does not mount media/", manager)
+				continue
+			}
+			if dlMount != mediaMount {
+				warn("%s sees %s/ and media/ through separate mounts (%s and %s); hard links cannot cross mounts, so imports from %s are copied. Mount %s once instead",
+					manager, dir, dlMount.Target, mediaMount.Target, client, mediaFolder)
+			}
+			if managerPath != clientPath {
+				warn("%s reports downloads in %s but %s sees them at %s; mount %s at the same path in both",
+					client, clientPath, manager, managerPath, mediaFolder)
+			}
+		}
+	}
+
+	return warnings
+}
+
+// pathDevice returns the device of an existing path
+func pathDevice(path string) (uint64, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	return deviceID(info)
+}
+
+// downloadDirs returns the directories the download clients use
+func downloadDirs() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, dir := range DownloadClients {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// containerPath returns the mount through which a service sees hostPath,
+// the most specific one when several cover it, and the path inside the
+// container
+func containerPath(mounts []Mount, hostPath string) (Mount, string, bool) {
+	var best Mount
+	var bestRel string
+	found := false
+	for _, m := range mounts {
+		rel, ok := relativeTo(m.Source, hostPath)
+		if !ok {
+			continue
+		}
+		if !found || len(m.Source) > len(best.Source) {
+			best, bestRel, found = m, rel, true
+		}
+	}
+	if !found {
+		return Mount{}, "", false
+	}
+	return best, path.Join(best.Target, filepath.ToSlash(bestRel)), true
+}
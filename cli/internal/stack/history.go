@@ -0,0 +1,163 @@
+package stack
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryFile is the journal of stack operations in the config directory,
+// one JSON entry per line
+const HistoryFile = "history.jsonl"
+
+// HistoryEntry records one deploy, stop, restart or pull
+type HistoryEntry struct {
+	ID           int               `json:"id"`
+	Time         time.Time         `json:"time"`
+	User         string            `json:"user"`
+	Host         string            `json:"host"`
+	Operation    string            `json:"operation"`
+	Args         []string          `json:"args,omitempty"`
+	Flags        []string          `json:"flags,omitempty"`
+	Variant      string            `json:"variant"`
+	Services     []string          `json:"services,omitempty"`
+	AllServices  bool              `json:"all_services,omitempty"` // Applied to the whole stack
+	ImagesBefore map[string]string `json:"images_before,omitempty"`
+	ImagesAfter  map[string]string `json:"images_after,omitempty"`
+	ConfigFiles  []string          `json:"config_files,omitempty"`
+	Duration     time.Duration     `json:"duration"`
+	Outcome      string            `json:"outcome"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// NewHistoryEntry starts an entry for an operation run now by the current user
+func NewHistoryEntry(operation, variant string, args, flags []string) *HistoryEntry {
+	entry := &HistoryEntry{
+		Time:      time.Now(),
+		Operation: operation,
+		Args:      args,
+		Flags:     flags,
+		Variant:   variant,
+	}
+	entry.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		entry.User = u.Username
+	}
+	return entry
+}
+
+// Finish records the duration and outcome of the operation
+func (e *HistoryEntry) Finish(opErr error) {
+	e.Duration = time.Since(e.Time).Round(time.Millisecond)
+	e.Outcome = "success"
+	if opErr != nil {
+		e.Outcome = "failure"
+		e.Error = opErr.Error()
+	}
+}
+
+// ServicesSummary describes the services the operation applied to: "all"
+// for the whole stack and "-" for none
+func (e *HistoryEntry) ServicesSummary() string {
+	switch {
+	case e.AllServices:
+		return "all"
+	case len(e.Services) == 0:
+		return "-"
+	}
+	return strings.Join(e.Services, ",")
+}
+
+// ChangedImages returns the services whose image ID differs before and after
+func (e *HistoryEntry) ChangedImages() []string {
+	var changed []string
+	for service, after := range e.ImagesAfter {
+		if e.ImagesBefore[service] != after {
+			changed = append(changed, service)
+		}
+	}
+	for service := range e.ImagesBefore {
+		if _, ok := e.ImagesAfter[service]; !ok {
+			changed = append(changed, service)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// AppendHistory assigns the entry the next ID and appends it to the journal.
+// Callers hold the stack lock, so IDs are not handed out twice.
+func AppendHistory(configDir string, entry *HistoryEntry) error {
+	entries, err := ReadHistory(configDir)
+	if err != nil {
+		return err
+	}
+	entry.ID = 1
+	if len(entries) > 0 {
+		entry.ID = entries[len(entries)-1].ID + 1
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(configDir, HistoryFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return f.Close()
+}
+
+// ReadHistory returns every journal entry, oldest first. Lines that cannot
+// be parsed (e.g. a write cut short by a crash) are skipped.
+func ReadHistory(configDir string) ([]HistoryEntry, error) {
+	f, err := os.Open(filepath.Join(configDir, HistoryFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	return entries, nil
+}
+
+// FindHistory returns the journal entry with the given ID
+func FindHistory(configDir string, id int) (*HistoryEntry, error) {
+	entries, err := ReadHistory(configDir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].ID == id {
+			return &entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("history entry %d not found", id)
+}
@@ -0,0 +1,188 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HooksDir is the directory next to .env that holds hook scripts
+const HooksDir = "hooks"
+
+// DeployLogFile is the log in the config directory that hook output is appended to
+const DeployLogFile = "deploy.log"
+
+// Hook names a point in an operation where a hook script runs
+type Hook string
+
+const (
+	HookPreDeploy  Hook = "pre-deploy"
+	HookPostDeploy Hook = "post-deploy"
+	HookPreStop    Hook = "pre-stop"
+	HookPostStop   Hook = "post-stop"
+	HookOnFailure  Hook = "on-failure"
+)
+
+// IsPre reports whether a failure of the hook should abort the operation
+func (h Hook) IsPre() bool {
+	return strings.HasPrefix(string(h), "pre-")
+}
+
+// HookEnv is the context passed to hook scripts as environment variables
+type HookEnv struct {
+	Operation string   // deploy or stop
+	Variant   string   // Compose variant
+	Project   string   // Compose project name
+	ConfigDir string   // Directory containing .env
+	Services  []string // Services changed by the operation
+	Result    string   // pending, success or failure
+	Error     string   // Error message when Result is failure
+}
+
+// environ returns the variables exported to a hook
+func (e HookEnv) environ(hook Hook) []string {
+	return []string{
+		"MEDIASTACK_HOOK=" + string(hook),
+		"MEDIASTACK_OPERATION=" + e.Operation,
+		"MEDIASTACK_VARIANT=" + e.Variant,
+		"MEDIASTACK_PROJECT=" + e.Project,
+		"MEDIASTACK_CONFIG_DIR=" + e.ConfigDir,
+		"MEDIASTACK_CHANGED_SERVICES=" + strings.Join(e.Services, " "),
+		"MEDIASTACK_RESULT=" + e.Result,
+		"MEDIASTACK_ERROR=" + e.Error,
+	}
+}
+
+// HookRunner executes hook scripts from the hooks directory
+type HookRunner struct {
+	dir     string
+	timeout time.Duration
+	output  io.Writer
+}
+
+// NewHookRunner creates a runner for the hooks in configDir. Hook output is
+// written to output.
+func NewHookRunner(configDir string, timeout time.Duration, output io.Writer) *HookRunner {
+	return &HookRunner{
+		dir:     filepath.Join(configDir, HooksDir),
+		timeout: timeout,
+		output:  output,
+	}
+}
+
+// Find returns the script for a hook, or an empty string if none exists.
+// Both "<hook>" and "<hook>.sh" are recognised.
+func (r *HookRunner) Find(hook Hook) string {
+	for _, name := range []string{string(hook), string(hook) + ".sh"} {
+		path := filepath.Join(r.dir, name)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path
+		}
+	}
+	return ""
+}
+
+// Run executes a hook if it exists. Scripts that are not executable are
+// run with sh. A hook that exceeds the timeout is killed and reported as
+// failed.
+func (r *HookRunner) Run(ctx context.Context, hook Hook, env HookEnv) error {
+	script := r.Find(hook)
+	if script == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if info, err := os.Stat(script); err == nil && info.Mode()&0111 != 0 {
+		cmd = exec.CommandContext(ctx, script)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", script)
+	}
+	cmd.Dir = filepath.Dir(r.dir)
+	cmd.Env = append(os.Environ(), env.environ(hook)...)
+	cmd.Stdout = r.output
+	cmd.Stderr = r.output
+	cmd.WaitDelay = 5 * time.Second
+
+	fmt.Fprintf(r.output, "=== %s hook %s (%s) ===\n", time.Now().Format(time.RFC3339), hook, script)
+	start := time.Now()
+	err := cmd.Run()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", r.timeout)
+	}
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	fmt.Fprintf(r.output, "=== %s hook finished in %s: %s ===\n", hook, time.Since(start).Round(time.Millisecond), status)
+
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w", hook, err)
+	}
+	return nil
+}
+
+// DefaultHookTimeout bounds each hook script unless overridden
+const DefaultHookTimeout = 5 * time.Minute
+
+// OpenDeployLog opens the deploy log in configDir for appending
+func OpenDeployLog(configDir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(configDir, DeployLogFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// HookSession runs the hooks around one operation
+type HookSession struct {
+	runner *HookRunner
+	Env    HookEnv
+}
+
+// NewHookSession creates a session for an operation described by env
+func NewHookSession(env HookEnv, timeout time.Duration, output io.Writer) *HookSession {
+	return &HookSession{
+		runner: NewHookRunner(env.ConfigDir, timeout, output),
+		Env:    env,
+	}
+}
+
+// SetServices records the services changed by the operation
+func (s *HookSession) SetServices(services []string) {
+	if s != nil {
+		s.Env.Services = services
+	}
+}
+
+// Pre runs a pre-* hook. A returned error means the operation must abort.
+func (s *HookSession) Pre(ctx context.Context, hook Hook) error {
+	if s == nil {
+		return nil
+	}
+	s.Env.Result = "pending"
+	return s.runner.Run(ctx, hook, s.Env)
+}
+
+// Finish runs the post hook when opErr is nil, or on-failure otherwise
+func (s *HookSession) Finish(ctx context.Context, post Hook, opErr error) error {
+	if s == nil {
+		return nil
+	}
+
+	hook := post
+	s.Env.Result = "success"
+	s.Env.Error = ""
+	if opErr != nil {
+		hook = HookOnFailure
+		s.Env.Result = "failure"
+		s.Env.Error = opErr.Error()
+	}
+
+	return s.runner.Run(ctx, hook, s.Env)
+}
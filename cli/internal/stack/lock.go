@@ -0,0 +1,223 @@
+package stack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// LockFile is the name of the advisory lock file in the config directory
+const LockFile = ".mediastack.lock"
+
+// lockTakeoverTimeout is how old a takeover guard must be before it is
+// considered abandoned by a process that crashed while replacing a stale lock
+const lockTakeoverTimeout = 10 * time.Second
+
+// LockInfo identifies the holder of the stack lock
+type LockInfo struct {
+	PID     int       `json:"pid"`
+	User    string    `json:"user"`
+	Host    string    `json:"host"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// String describes the lock holder for error messages
+func (i LockInfo) String() string {
+	return fmt.Sprintf("%q by %s@%s (pid %d) since %s",
+		i.Command, i.User, i.Host, i.PID, i.Started.Local().Format("2006-01-02 15:04:05"))
+}
+
+// Stale reports whether the lock was taken on this host by a process that
+// no longer exists. Locks from other hosts are never considered stale.
+func (i LockInfo) Stale() bool {
+	host, _ := os.Hostname()
+	return i.Host == host && !processExists(i.PID)
+}
+
+// LockedError is returned when another operation holds the lock
+type LockedError struct {
+	Holder LockInfo
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder.PID == 0 {
+		return "another operation is in progress (its lock file is being written or unreadable)\nIf it is no longer running, use: mediastack unlock --force"
+	}
+	return fmt.Sprintf("another operation is in progress: %s\nIf it is no longer running, use: mediastack unlock --force", e.Holder)
+}
+
+// Lock is a held stack lock
+type Lock struct {
+	path string
+	data []byte // Contents of the lock file as written
+}
+
+// AcquireLock takes the advisory stack lock in configDir for command.
+// Stale locks left by crashed processes on this host are replaced.
+func AcquireLock(configDir, command string) (*Lock, error) {
+	path := filepath.Join(configDir, LockFile)
+
+	info := LockInfo{
+		PID:     os.Getpid(),
+		Command: command,
+		Started: time.Now(),
+	}
+	info.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		info.User = u.Username
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the lock to a private file and hard-link it into place, so the
+	// lock file appears complete or not at all
+	tmp := fmt.Sprintf("%s.%d.tmp", path, info.PID)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	for attempt := 0; attempt < 5; attempt++ {
+		err := os.Link(tmp, path)
+		if err == nil {
+			return &Lock{path: path, data: data}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		raw, holder, err := readLockFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			// Released between our attempts
+			continue
+		}
+		if holder == nil {
+			// Unparsable, e.g. still being written by an older version
+			return nil, &LockedError{}
+		}
+		if !holder.Stale() {
+			return nil, &LockedError{Holder: *holder}
+		}
+
+		// The holder crashed without releasing the lock. When another
+		// process is busy taking over, retry once it is done.
+		if _, err := removeLockIf(path, raw); err != nil {
+			return nil, fmt.Errorf("failed to remove stale lock: %w", err)
+		}
+	}
+
+	return nil, fmt.Errorf("failed to acquire lock: %s", path)
+}
+
+// removeLockIf deletes the lock file if it still contains want. Processes
+// removing a lock serialise on a guard file and re-read the lock while
+// holding it, so a lock created by another process after want was read is
+// never removed. It returns false without removing anything when the guard
+// is busy; the caller retries.
+func removeLockIf(path string, want []byte) (bool, error) {
+	guard := path + ".takeover"
+	f, err := os.OpenFile(guard, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		// Unless its holder crashed while holding it
+		if fi, err := os.Stat(guard); err == nil && time.Since(fi.ModTime()) > lockTakeoverTimeout {
+			os.Remove(guard)
+		}
+		time.Sleep(50 * time.Millisecond)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(guard)
+
+	current, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	if !bytes.Equal(current, want) {
+		// Replaced since it was read
+		return true, nil
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+	return true, nil
+}
+
+// Release removes the lock file if it is still this lock. After 'unlock
+// --force' another process may hold the lock, and it is left alone.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	deadline := time.Now().Add(lockTakeoverTimeout + time.Second)
+	for {
+		done, err := removeLockIf(l.path, l.data)
+		if err != nil {
+			return fmt.Errorf("failed to release lock: %w", err)
+		}
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("failed to release lock: %s is busy", l.path+".takeover")
+		}
+	}
+}
+
+// ReadLock returns the current lock holder, or nil if the stack is unlocked
+func ReadLock(configDir string) (*LockInfo, error) {
+	raw, info, err := readLockFile(filepath.Join(configDir, LockFile))
+	if err != nil {
+		return nil, err
+	}
+	if raw != nil && info == nil {
+		return nil, errors.New("invalid lock file")
+	}
+	return info, nil
+}
+
+// readLockFile returns the contents of the lock file at path, nil if it does
+// not exist, and its holder, nil if the contents cannot be parsed
+func readLockFile(path string) ([]byte, *LockInfo, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return data, nil, nil
+	}
+	return data, &info, nil
+}
+
+// RemoveLock deletes the lock file regardless of its holder
+func RemoveLock(configDir string) error {
+	err := os.Remove(filepath.Join(configDir, LockFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package stack
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// deadPID returns the PID of a process that has exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+// writeLock writes a lock file held by info into configDir
+func writeLock(t *testing.T, configDir string, info LockInfo) {
+	t.Helper()
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, LockFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLockTakesOverStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	host, _ := os.Hostname()
+	writeLock(t, dir, LockInfo{PID: deadPID(t), Host: host, Command: "crashed", Started: time.Now()})
+
+	lock, err := AcquireLock(dir, "test")
+	if err != nil {
+		t.Fatalf("AcquireLock: %v", err)
+	}
+	defer lock.Release()
+
+	holder, err := ReadLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder == nil || holder.PID != os.Getpid() || holder.Command != "test" {
+		t.Errorf("holder = %+v, want this process", holder)
+	}
+}
+
+func TestAcquireLockHeld(t *testing.T) {
+	host, _ := os.Hostname()
+
+	tests := []struct {
+		name     string
+		contents func(t *testing.T) []byte
+		wantPID  int
+	}{
+		{
+			name: "live holder",
+			contents: func(t *testing.T) []byte {
+				data, _ := json.Marshal(LockInfo{PID: os.Getpid(), Host: host, Command: "deploy"})
+				return data
+			},
+			wantPID: os.Getpid(),
+		},
+		{
+			name: "other host",
+			contents: func(t *testing.T) []byte {
+				data, _ := json.Marshal(LockInfo{PID: deadPID(t), Host: host + "-elsewhere", Command: "deploy"})
+				return data
+			},
+			wantPID: -1,
+		},
+		{
+			name:     "partial lock file",
+			contents: func(t *testing.T) []byte { return []byte(`{"pid": 12`) },
+		},
+		{
+			name:     "empty lock file",
+			contents: func(t *testing.T) []byte { return nil },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, LockFile)
+			contents := tt.contents(t)
+			if err := os.WriteFile(path, contents, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			lock, err := AcquireLock(dir, "test")
+			if err == nil {
+				lock.Release()
+				t.Fatal("AcquireLock succeeded, want LockedError")
+			}
+			var locked *LockedError
+			if !errors.As(err, &locked) {
+				t.Fatalf("AcquireLock: %v, want LockedError", err)
+			}
+			if tt.wantPID > 0 && locked.Holder.PID != tt.wantPID {
+				t.Errorf("holder PID = %d, want %d", locked.Holder.PID, tt.wantPID)
+			}
+
+			// The holder's lock file is left alone
+			if data, err := os.ReadFile(path); err != nil || string(data) != string(contents) {
+				t.Errorf("lock file changed to %q (%v)", data, err)
+			}
+		})
+	}
+}
+
+func TestReleaseKeepsNewHoldersLock(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := AcquireLock(dir, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unlock --force, then another operation takes the lock
+	if err := RemoveLock(dir); err != nil {
+		t.Fatal(err)
+	}
+	second, err := AcquireLock(dir, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	holder, err := ReadLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder == nil || holder.Command != "second" {
+		t.Fatalf("holder after releasing the first lock = %+v, want second", holder)
+	}
+
+	if err := second.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if holder, err := ReadLock(dir); err != nil || holder != nil {
+		t.Errorf("holder after release = %+v (%v), want none", holder, err)
+	}
+}
@@ -0,0 +1,340 @@
+package stack
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MigrationFile records storage migrations in the config directory until
+// the old tree is cleaned up
+const MigrationFile = ".mediastack-migrations.json"
+
+// partialSuffix marks a file whose copy has not finished and been verified
+const partialSuffix = ".mediastack-partial"
+
+// Migration is a move of FOLDER_FOR_DATA or FOLDER_FOR_MEDIA to a new path
+type Migration struct {
+	Variable  string    `json:"variable"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Started   time.Time `json:"started"`
+	Completed time.Time `json:"completed,omitempty"`
+}
+
+// LoadMigrations returns the recorded migrations, keyed by variable
+func LoadMigrations(configDir string) (map[string]Migration, error) {
+	data, err := os.ReadFile(filepath.Join(configDir, MigrationFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]Migration{}, nil
+		}
+		return nil, err
+	}
+	migrations := map[string]Migration{}
+	if err := json.Unmarshal(data, &migrations); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", MigrationFile, err)
+	}
+	return migrations, nil
+}
+
+// SaveMigrations writes the migration records, removing the file when
+// there are none left
+func SaveMigrations(configDir string, migrations map[string]Migration) error {
+	path := filepath.Join(configDir, MigrationFile)
+	if len(migrations) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(migrations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// CopyStats counts the progress of CopyTree
+type CopyStats struct {
+	Total       int64 // bytes to copy, hard links counted once
+	Bytes       int64 // bytes copied or already present
+	Files       int64 // files copied
+	Skipped     int64 // files already copied by an earlier run
+	Linked      int64 // hard links recreated
+	Dirs        int64
+	ChownFailed int64
+}
+
+// treeCopy is the state of one CopyTree run
+type treeCopy struct {
+	src, dst   string
+	stats      CopyStats
+	links      map[fileID]string
+	progress   func(CopyStats)
+	lastReport time.Time
+}
+
+// CopyTree copies the tree at src to dst like cp -a: modes, owners, times,
+// symlinks and hard links are preserved. Each file is written to a
+// temporary name, verified against the source by SHA-256 and only then
+// renamed into place, so an interrupted copy can be resumed by running it
+// again: finished files are skipped and partial ones are continued.
+// total is the size of src from DirSize, for progress. progress, if set, is
+// called about once a second.
+func CopyTree(src, dst string, total int64, progress func(CopyStats)) (CopyStats, error) {
+	c := &treeCopy{
+		src:      src,
+		dst:      dst,
+		links:    make(map[fileID]string),
+		progress: progress,
+	}
+	c.stats.Total = total
+
+	type dirAttrs struct {
+		path string
+		info fs.FileInfo
+	}
+	var dirs []dirAttrs
+
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirAttrs{target, info})
+			c.stats.Dirs++
+			return nil
+
+		case info.Mode()&fs.ModeSymlink != 0:
+			if err := c.copySymlink(p, target); err != nil {
+				return err
+			}
+
+		case info.Mode().IsRegular():
+			if strings.HasSuffix(p, partialSuffix) {
+				return nil
+			}
+			if err := c.copyRegular(p, target, info); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", p, err)
+			}
+
+		default:
+			// Sockets, pipes and devices are recreated by their owners
+			return nil
+		}
+
+		c.chown(target, info)
+		c.report(false)
+		return nil
+	})
+	if err != nil {
+		return c.stats, err
+	}
+
+	// Directory attributes last, since writing files changes their mtimes
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		os.Chmod(d.path, d.info.Mode()&(fs.ModePerm|fs.ModeSetgid|fs.ModeSetuid|fs.ModeSticky))
+		c.chown(d.path, d.info)
+		os.Chtimes(d.path, d.info.ModTime(), d.info.ModTime())
+	}
+
+	c.report(true)
+	return c.stats, nil
+}
+
+// report calls the progress callback at most once a second, or now
+func (c *treeCopy) report(now bool) {
+	if c.progress == nil || (!now && time.Since(c.lastReport) < time.Second) {
+		return
+	}
+	c.lastReport = time.Now()
+	c.progress(c.stats)
+}
+
+// chown gives target the owner of the source file
+func (c *treeCopy) chown(target string, info fs.FileInfo) {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return
+	}
+	if err := os.Lchown(target, uid, gid); err != nil {
+		c.stats.ChownFailed++
+	}
+}
+
+// copySymlink recreates a symlink unless an identical one exists
+func (c *treeCopy) copySymlink(src, target string) error {
+	link, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if existing, err := os.Readlink(target); err == nil {
+		if existing == link {
+			return nil
+		}
+		os.Remove(target)
+	}
+	return os.Symlink(link, target)
+}
+
+// copyRegular copies a regular file, or links it to an earlier copy of the
+// same inode
+func (c *treeCopy) copyRegular(src, target string, info fs.FileInfo) error {
+	id, linked := hardLinkID(info)
+	if linked {
+		if first, ok := c.links[id]; ok {
+			return c.link(first, target)
+		}
+		c.links[id] = target
+	}
+
+	// A file with the same size and mtime was copied by an earlier run
+	if existing, err := os.Stat(target); err == nil && existing.Mode().IsRegular() &&
+		existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+		c.stats.Skipped++
+		c.stats.Bytes += info.Size()
+		return nil
+	}
+
+	partial := target + partialSuffix
+	sum, err := c.copyData(src, partial, info.Size())
+	if err != nil {
+		return err
+	}
+	if err := verifyCopy(sum, partial); err != nil {
+		os.Remove(partial)
+		return err
+	}
+
+	os.Chmod(partial, info.Mode().Perm()|info.Mode()&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+	os.Chtimes(partial, info.ModTime(), info.ModTime())
+	if err := os.Rename(partial, target); err != nil {
+		return err
+	}
+	c.stats.Files++
+	return nil
+}
+
+// link makes target a hard link to first
+func (c *treeCopy) link(first, target string) error {
+	if a, err := os.Stat(first); err == nil {
+		if b, err := os.Stat(target); err == nil && os.SameFile(a, b) {
+			c.stats.Skipped++
+			return nil
+		}
+	}
+	os.Remove(target)
+	if err := os.Link(first, target); err != nil {
+		return err
+	}
+	c.stats.Linked++
+	return nil
+}
+
+// copyData copies src into partial, continuing a partial file left by an
+// interrupted run, and returns the SHA-256 checksum of src. The source is
+// hashed as it is read, so it is only read once.
+func (c *treeCopy) copyData(src, partial string, size int64) ([]byte, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if offset > size {
+		if err := out.Truncate(0); err != nil {
+			return nil, err
+		}
+		offset, _ = out.Seek(0, io.SeekStart)
+	}
+
+	// The part copied by an earlier run still counts towards the checksum
+	sum := sha256.New()
+	if _, err := io.CopyN(sum, in, offset); err != nil {
+		return nil, err
+	}
+	c.stats.Bytes += offset
+
+	buf := make([]byte, 4<<20)
+	r := io.TeeReader(in, sum)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				return nil, werr
+			}
+			c.stats.Bytes += int64(n)
+			c.report(false)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sum.Sum(nil), out.Close()
+}
+
+// verifyCopy compares the SHA-256 checksum of a copied file with want, the
+// checksum of its source
+func verifyCopy(want []byte, path string) error {
+	got, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("checksum mismatch after copying; the file may have changed or the destination is faulty")
+	}
+	return nil
+}
+
+// fileChecksum returns the SHA-256 checksum of a file
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,168 @@
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jxmullins/mediastack/internal/docker"
+)
+
+// Mount is a service's bind mount of a path in FOLDER_FOR_DATA or
+// FOLDER_FOR_MEDIA
+type Mount struct {
+	Service  string `json:"service"`
+	Source   string `json:"source"` // Host path
+	Target   string `json:"target"` // Path in the container
+	ReadOnly bool   `json:"read_only"`
+	User     string `json:"user,omitempty"` // uid:gid the service runs as, empty for the image default
+	File     bool   `json:"file"`           // Source is a deployed config file, not a directory
+}
+
+// Directories are the host directories the stack needs, derived from the
+// bind mounts of the rendered compose model
+type Directories struct {
+	DataFolder  string
+	MediaFolder string
+	Data        []string // Relative to DataFolder
+	Media       []string // Relative to MediaFolder, including MediaDirectories
+	Mounts      []Mount
+}
+
+// RequiredDirectories collects the bind mounts of project's services that
+// live in dataFolder or mediaFolder. Only services in the rendered model
+// count, so directories of services the variant does not run are left out.
+func RequiredDirectories(project *docker.Project, dataFolder, mediaFolder string) *Directories {
+	dirs := &Directories{DataFolder: dataFolder, MediaFolder: mediaFolder}
+
+	configFiles := make(map[string]bool)
+	for _, cf := range ConfigFiles {
+		configFiles[filepath.Join(dataFolder, cf.Destination)] = true
+	}
+
+	data := make(map[string]bool)
+	media := make(map[string]bool)
+	for _, dir := range MediaDirectories {
+		media[dir] = true
+	}
+
+	for _, name := range project.ServiceNames() {
+		svc := project.Services[name]
+		for _, v := range svc.Volumes {
+			if v.Type != "bind" {
+				continue
+			}
+			source := filepath.Clean(v.Source)
+
+			var rel string
+			var set map[string]bool
+			if r, ok := relativeTo(dataFolder, source); ok {
+				rel, set = r, data
+			} else if r, ok := relativeTo(mediaFolder, source); ok {
+				rel, set = r, media
+			} else {
+				continue
+			}
+
+			m := Mount{
+				Service:  name,
+				Source:   source,
+				Target:   v.Target,
+				ReadOnly: v.ReadOnly,
+				User:     svc.RunsAs(),
+				File:     configFiles[source],
+			}
+			dirs.Mounts = append(dirs.Mounts, m)
+
+			if m.File {
+				rel = filepath.Dir(rel)
+			}
+			if rel != "." {
+				set[filepath.ToSlash(rel)] = true
+			}
+		}
+	}
+
+	dirs.Data = sortedKeys(data)
+	dirs.Media = sortedKeys(media)
+	return dirs
+}
+
+// relativeTo returns path relative to root if it is root or inside it
+func relativeTo(root, path string) (string, bool) {
+	if root == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(filepath.Clean(root), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Paths returns the absolute paths of all required directories
+func (d *Directories) Paths() []string {
+	paths := make([]string, 0, len(d.Data)+len(d.Media))
+	for _, dir := range d.Data {
+		paths = append(paths, filepath.Join(d.DataFolder, dir))
+	}
+	for _, dir := range d.Media {
+		paths = append(paths, filepath.Join(d.MediaFolder, dir))
+	}
+	return paths
+}
+
+// ServiceDataDirectories returns the top-level directories of the data
+// mounts, e.g. "authentik" for authentik/media
+func (d *Directories) ServiceDataDirectories() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, dir := range d.Data {
+		top := strings.SplitN(dir, "/", 2)[0]
+		if !seen[top] {
+			seen[top] = true
+			dirs = append(dirs, top)
+		}
+	}
+	return dirs
+}
+
+// MountProblem is a bind mount whose host path docker cannot use as is
+type MountProblem struct {
+	Mount
+	Missing bool   `json:"missing"`
+	Problem string `json:"problem"`
+}
+
+// CheckMounts reports mounts whose host path is missing, which docker would
+// create owned by root, or is a file where a directory is mounted. Missing
+// config files are left to VerifyConfigFiles.
+func (d *Directories) CheckMounts() []MountProblem {
+	var problems []MountProblem
+	for _, m := range d.Mounts {
+		info, err := os.Stat(m.Source)
+		switch {
+		case os.IsNotExist(err):
+			if !m.File {
+				problems = append(problems, MountProblem{Mount: m, Missing: true, Problem: "does not exist"})
+			}
+		case err != nil:
+			problems = append(problems, MountProblem{Mount: m, Problem: "cannot be read: " + err.Error()})
+		case !m.File && !info.IsDir():
+			problems = append(problems, MountProblem{Mount: m, Problem: "is a file, not a directory"})
+		case m.File && info.IsDir():
+			problems = append(problems, MountProblem{Mount: m, Problem: "is a directory, not a file"})
+		}
+	}
+	return problems
+}
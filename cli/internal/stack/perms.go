@@ -0,0 +1,415 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Mode bits every path must have. Bits are only ever added, never removed:
+// executables keep their x bits, and FOLDER_FOR_DATA only needs owner
+// access because some apps insist on private modes (PostgreSQL refuses a
+// data directory other than 0700 or 0750). FOLDER_FOR_MEDIA is shared by
+// the download clients and *arr apps through the group, with setgid
+// directories so new files inherit it.
+const (
+	dataDirBits   = 0700
+	dataFileBits  = 0600
+	mediaDirBits  = 0775 | fs.ModeSetgid
+	mediaFileBits = 0664
+)
+
+// PermIssue is a path whose owner or mode is not what the stack expects
+type PermIssue struct {
+	Path       string `json:"path"`
+	Dir        bool   `json:"dir"`
+	UID        int    `json:"uid"`
+	GID        int    `json:"gid"`
+	Mode       uint32 `json:"mode"`
+	WantMode   uint32 `json:"want_mode"`
+	WrongOwner bool   `json:"wrong_owner"`
+	WrongMode  bool   `json:"wrong_mode"`
+}
+
+// PermGroup is the result of checking the tree of one bind mount
+type PermGroup struct {
+	Source   string      `json:"source"`
+	Services []string    `json:"services"`
+	Media    bool        `json:"media"`
+	Skipped  string      `json:"skipped,omitempty"` // Why the tree was not checked
+	Checked  int64       `json:"checked"`
+	Issues   []PermIssue `json:"issues"`
+	Errors   []string    `json:"errors,omitempty"`
+}
+
+// PermReport is the result of ScanPermissions
+type PermReport struct {
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+	Groups  []PermGroup `json:"groups"`
+	Checked int64       `json:"checked"`
+	Issues  int         `json:"issues"`
+}
+
+// permGroups groups the directory mounts of dirs by host path. A tree
+// that a service writes to as a user other than uid is skipped, since its
+// files are meant to belong to that user.
+func permGroups(dirs *Directories, uid int, services []string) []PermGroup {
+	only := make(map[string]bool)
+	for _, s := range services {
+		only[s] = true
+	}
+
+	bySource := make(map[string]*PermGroup)
+	var sources []string
+	for _, m := range dirs.Mounts {
+		if m.File || (len(only) > 0 && !only[m.Service]) {
+			continue
+		}
+		g := bySource[m.Source]
+		if g == nil {
+			_, media := relativeTo(dirs.MediaFolder, m.Source)
+			g = &PermGroup{Source: m.Source, Media: media}
+			bySource[m.Source] = g
+			sources = append(sources, m.Source)
+		}
+		if len(g.Services) == 0 || g.Services[len(g.Services)-1] != m.Service {
+			g.Services = append(g.Services, m.Service)
+		}
+		if g.Skipped == "" && !m.ReadOnly && !runsAsUID(m.User, uid) {
+			user := m.User
+			if user == "" {
+				user = "the image's default user"
+			}
+			g.Skipped = fmt.Sprintf("written by %s as %s", m.Service, user)
+		}
+	}
+
+	sort.Strings(sources)
+	groups := make([]PermGroup, 0, len(sources))
+	for _, source := range sources {
+		groups = append(groups, *bySource[source])
+	}
+	return groups
+}
+
+// runsAsUID reports whether a uid[:gid] user spec is uid
+func runsAsUID(user string, uid int) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == strconv.Itoa(uid)
+}
+
+// ScanPermissions checks the owner and mode of every file and directory in
+// the mounted trees of dirs, parallel directories at a time. Only the
+// trees of services are checked when any are given. Hard-linked files are
+// checked once.
+func ScanPermissions(dirs *Directories, uid, gid, parallel int, services []string) *PermReport {
+	report := &PermReport{UID: uid, GID: gid, Groups: permGroups(dirs, uid, services)}
+
+	groupOf := make(map[string]int, len(report.Groups))
+	for i, g := range report.Groups {
+		groupOf[g.Source] = i
+	}
+
+	var mu sync.Mutex
+	checked := make([]int64, len(report.Groups))
+	seen := make(map[fileID]bool)
+
+	check := func(group int, path string, info fs.FileInfo) {
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return
+		}
+		if id, ok := hardLinkID(info); ok {
+			mu.Lock()
+			dup := seen[id]
+			seen[id] = true
+			mu.Unlock()
+			if dup {
+				return
+			}
+		}
+		atomic.AddInt64(&checked[group], 1)
+
+		issue, bad := checkPermissions(path, info, report.Groups[group].Media, uid, gid)
+		if bad {
+			mu.Lock()
+			report.Groups[group].Issues = append(report.Groups[group].Issues, issue)
+			mu.Unlock()
+		}
+	}
+	fail := func(group int, err error) {
+		mu.Lock()
+		report.Groups[group].Errors = append(report.Groups[group].Errors, err.Error())
+		mu.Unlock()
+	}
+
+	// Each tree is walked from its outermost mount; nested mounts take
+	// over the paths below them, and skipped ones are left out
+	var roots []walkDir
+	for i, g := range report.Groups {
+		if g.Skipped != "" || nestedGroup(report.Groups, g.Source) {
+			continue
+		}
+		info, err := os.Lstat(g.Source)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fail(i, err)
+			}
+			continue
+		}
+		check(i, g.Source, info)
+		if info.IsDir() {
+			roots = append(roots, walkDir{path: g.Source, group: i})
+		}
+	}
+
+	walkParallel(roots, parallel, func(parent walkDir, path string, info fs.FileInfo) (walkDir, bool) {
+		group := parent.group
+		if i, ok := groupOf[path]; ok {
+			if report.Groups[i].Skipped != "" {
+				return walkDir{}, false
+			}
+			group = i
+		}
+		check(group, path, info)
+		return walkDir{path: path, group: group}, info.IsDir()
+	}, func(dir walkDir, err error) {
+		fail(dir.group, err)
+	})
+
+	for i := range report.Groups {
+		g := &report.Groups[i]
+		g.Checked = checked[i]
+		sort.Slice(g.Issues, func(a, b int) bool { return g.Issues[a].Path < g.Issues[b].Path })
+		report.Checked += g.Checked
+		report.Issues += len(g.Issues)
+	}
+	return report
+}
+
+// nestedGroup reports whether source is inside the tree of another group
+// that is walked
+func nestedGroup(groups []PermGroup, source string) bool {
+	for _, g := range groups {
+		if g.Skipped != "" || g.Source == source {
+			continue
+		}
+		if _, ok := relativeTo(g.Source, source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPermissions compares a path with the expected owner and mode bits
+func checkPermissions(path string, info fs.FileInfo, media bool, uid, gid int) (PermIssue, bool) {
+	issue := PermIssue{Path: path, Dir: info.IsDir(), UID: -1, GID: -1}
+
+	if fileUID, fileGID, ok := fileOwner(info); ok {
+		issue.UID, issue.GID = fileUID, fileGID
+		issue.WrongOwner = fileUID != uid || fileGID != gid
+	}
+
+	var want fs.FileMode
+	switch {
+	case info.IsDir() && media:
+		want = mediaDirBits
+	case info.IsDir():
+		want = dataDirBits
+	case media:
+		want = mediaFileBits
+	default:
+		want = dataFileBits
+	}
+	mode := info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	issue.Mode = unixMode(mode)
+	issue.WantMode = unixMode(mode | want)
+	issue.WrongMode = mode|want != mode
+
+	return issue, issue.WrongOwner || issue.WrongMode
+}
+
+// unixMode converts a FileMode to the chmod numbering
+func unixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// fileMode converts a chmod mode to a FileMode
+func fileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// PermFailure is a path FixPermissions could not change
+type PermFailure struct {
+	Path   string `json:"path"`
+	Error  string `json:"error"`
+	Denied bool   `json:"denied"` // Not permitted, e.g. chown without root
+}
+
+// PermFixResult counts the changes made by FixPermissions
+type PermFixResult struct {
+	Chowned  int64         `json:"chowned"`
+	Chmodded int64         `json:"chmodded"`
+	Failures []PermFailure `json:"failures"`
+}
+
+// FixPermissions changes the owner and mode of the paths in report, and
+// nothing else, parallel paths at a time
+func FixPermissions(report *PermReport, parallel int) *PermFixResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	result := &PermFixResult{}
+	var mu sync.Mutex
+	fail := func(path string, errs []error) {
+		failure := PermFailure{Path: path}
+		var msgs []string
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+			failure.Denied = failure.Denied || errors.Is(err, fs.ErrPermission)
+		}
+		failure.Error = strings.Join(msgs, "; ")
+
+		mu.Lock()
+		result.Failures = append(result.Failures, failure)
+		mu.Unlock()
+	}
+
+	issues := make(chan PermIssue)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for issue := range issues {
+				// chown may clear setgid on files, so it goes first
+				var errs []error
+				if issue.WrongOwner {
+					if err := os.Lchown(issue.Path, report.UID, report.GID); err != nil {
+						errs = append(errs, err)
+					} else {
+						atomic.AddInt64(&result.Chowned, 1)
+					}
+				}
+				if issue.WrongMode || (issue.WrongOwner && issue.Mode&06000 != 0) {
+					if err := os.Chmod(issue.Path, fileMode(issue.WantMode)); err != nil {
+						errs = append(errs, err)
+					} else if issue.WrongMode {
+						atomic.AddInt64(&result.Chmodded, 1)
+					}
+				}
+				if len(errs) > 0 {
+					fail(issue.Path, errs)
+				}
+			}
+		}()
+	}
+
+	for _, g := range report.Groups {
+		for _, issue := range g.Issues {
+			issues <- issue
+		}
+	}
+	close(issues)
+	wg.Wait()
+
+	sort.Slice(result.Failures, func(i, j int) bool { return result.Failures[i].Path < result.Failures[j].Path })
+	return result
+}
+
+// walkDir is a directory queued by walkParallel, with the state its
+// entries inherit
+type walkDir struct {
+	path  string
+	group int
+}
+
+// walkParallel reads the directories below roots with parallel workers.
+// visit is called for every entry (not following symlinks) and returns the
+// state for its children and whether to descend into it. Unreadable
+// directories are passed to fail and skipped.
+func walkParallel(roots []walkDir, parallel int, visit func(parent walkDir, path string, info fs.FileInfo) (walkDir, bool), fail func(walkDir, error)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	queue := append([]walkDir(nil), roots...)
+	pending := len(queue)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 {
+					cond.Wait()
+				}
+				if pending == 0 {
+					mu.Unlock()
+					return
+				}
+				dir := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mu.Unlock()
+
+				var children []walkDir
+				entries, err := os.ReadDir(dir.path)
+				if err != nil {
+					fail(dir, err)
+				}
+				for _, e := range entries {
+					path := filepath.Join(dir.path, e.Name())
+					info, err := e.Info()
+					if err != nil {
+						if !os.IsNotExist(err) {
+							fail(dir, err)
+						}
+						continue
+					}
+					if child, descend := visit(dir, path, info); descend {
+						children = append(children, child)
+					}
+				}
+
+				mu.Lock()
+				queue = append(queue, children...)
+				pending += len(children) - 1
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,81 @@
+package stack
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFileStatus describes how a deployed config file compares to its source
+type ConfigFileStatus string
+
+const (
+	ConfigFileNew           ConfigFileStatus = "new"
+	ConfigFileChanged       ConfigFileStatus = "changed"
+	ConfigFileUnchanged     ConfigFileStatus = "unchanged"
+	ConfigFileMissingSource ConfigFileStatus = "missing-source"
+	ConfigFileKeepLocal     ConfigFileStatus = "keep-local"
+)
+
+// ConfigFileChange is the planned outcome of copying one config file
+type ConfigFileChange struct {
+	Source      string           `json:"source"`
+	Destination string           `json:"destination"`
+	Service     string           `json:"service"`
+	Status      ConfigFileStatus `json:"status"`
+	Diff        string           `json:"diff,omitempty"`
+}
+
+// PlanConfigFiles compares each config file, rendered with env, with the
+// copy deployed in the data folder without modifying anything. Changed
+// files whose source name is listed in keepLocal are reported as
+// keep-local, since deploy leaves them alone.
+func PlanConfigFiles(configDir, dataFolder string, env map[string]string, keepLocal []string) ([]ConfigFileChange, error) {
+	var changes []ConfigFileChange
+
+	keep := make(map[string]bool, len(keepLocal))
+	for _, name := range keepLocal {
+		keep[name] = true
+	}
+
+	for _, cf := range ConfigFiles {
+		src := filepath.Join(configDir, cf.Source)
+		dst := filepath.Join(dataFolder, cf.Destination)
+
+		change := ConfigFileChange{
+			Source:      cf.Source,
+			Destination: dst,
+			Service:     cf.Service,
+		}
+
+		srcData, err := RenderConfigFile(configDir, cf, env)
+		if os.IsNotExist(err) {
+			change.Status = ConfigFileMissingSource
+			changes = append(changes, change)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", cf.Source, err)
+		}
+
+		dstData, err := os.ReadFile(dst)
+		switch {
+		case os.IsNotExist(err):
+			change.Status = ConfigFileNew
+		case err != nil:
+			return nil, fmt.Errorf("failed to read %s: %w", dst, err)
+		case bytes.Equal(srcData, dstData):
+			change.Status = ConfigFileUnchanged
+		default:
+			change.Status = ConfigFileChanged
+			if keep[cf.Source] {
+				change.Status = ConfigFileKeepLocal
+			}
+			change.Diff = UnifiedDiff(string(dstData), string(srcData), dst, src)
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
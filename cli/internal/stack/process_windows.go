@@ -0,0 +1,18 @@
+//go:build windows
+
+package stack
+
+import "os"
+
+// processExists reports whether a process with the given PID is running
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,174 @@
+package stack
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UnresolvedVariable is a ${VAR} reference in a config file with no value
+type UnresolvedVariable struct {
+	Name    string
+	Line    int
+	Message string // from ${VAR:?message}
+}
+
+// UnresolvedError is returned when a config file references variables
+// that are not set in .env
+type UnresolvedError struct {
+	File string
+	Vars []UnresolvedVariable
+}
+
+func (e *UnresolvedError) Error() string {
+	parts := make([]string, len(e.Vars))
+	for i, v := range e.Vars {
+		parts[i] = fmt.Sprintf("%s (line %d)", v.Name, v.Line)
+		if v.Message != "" {
+			parts[i] += ": " + v.Message
+		}
+	}
+	return fmt.Sprintf("%s: unresolved variables: %s", e.File, strings.Join(parts, ", "))
+}
+
+// RenderTemplate interpolates variables in a config file the way Docker
+// Compose does: ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?message}
+// and ${VAR?message}. $$ produces a literal $. A $ not followed by { or $
+// is left as is, and so are comment lines starting with #. Every
+// unresolved variable is reported in one error.
+func RenderTemplate(name string, data []byte, env map[string]string) ([]byte, error) {
+	var out bytes.Buffer
+	out.Grow(len(data))
+
+	var unresolved []UnresolvedVariable
+	line := 1
+	for i := 0; i < len(data); i++ {
+		if i == 0 || data[i-1] == '\n' {
+			if rest := bytes.TrimLeft(data[i:], " \t"); len(rest) > 0 && rest[0] == '#' {
+				end := bytes.IndexByte(data[i:], '\n')
+				if end == -1 {
+					end = len(data) - i
+				}
+				out.Write(data[i : i+end])
+				i += end - 1
+				continue
+			}
+		}
+
+		c := data[i]
+		if c == '\n' {
+			line++
+		}
+		if c != '$' || i+1 >= len(data) {
+			out.WriteByte(c)
+			continue
+		}
+
+		switch data[i+1] {
+		case '$':
+			out.WriteByte('$')
+			i++
+			continue
+		case '{':
+		default:
+			out.WriteByte(c)
+			continue
+		}
+
+		end := bytes.IndexByte(data[i+2:], '}')
+		if end == -1 || bytes.IndexByte(data[i+2:i+2+end], '\n') != -1 {
+			// Not a complete reference; leave it alone
+			out.WriteByte(c)
+			continue
+		}
+
+		expr := string(data[i+2 : i+2+end])
+		value, ok, message := resolveVariable(expr, env)
+		if !ok {
+			unresolved = append(unresolved, UnresolvedVariable{
+				Name:    variableName(expr),
+				Line:    line,
+				Message: message,
+			})
+		}
+		out.WriteString(value)
+		i += 2 + end
+	}
+
+	if len(unresolved) > 0 {
+		return nil, &UnresolvedError{File: name, Vars: unresolved}
+	}
+	return out.Bytes(), nil
+}
+
+// resolveVariable evaluates the expression inside ${...}
+func resolveVariable(expr string, env map[string]string) (value string, ok bool, message string) {
+	name := variableName(expr)
+	if name == "" {
+		return "", false, "invalid reference ${" + expr + "}"
+	}
+	op := expr[len(name):]
+	val, set := env[name]
+
+	switch {
+	case op == "":
+		return val, set, ""
+	case strings.HasPrefix(op, ":-"):
+		if !set || val == "" {
+			return op[2:], true, ""
+		}
+		return val, true, ""
+	case strings.HasPrefix(op, "-"):
+		if !set {
+			return op[1:], true, ""
+		}
+		return val, true, ""
+	case strings.HasPrefix(op, ":?"):
+		if !set || val == "" {
+			return "", false, op[2:]
+		}
+		return val, true, ""
+	case strings.HasPrefix(op, "?"):
+		if !set {
+			return "", false, op[1:]
+		}
+		return val, true, ""
+	}
+
+	// Unknown modifier, e.g. a typo in the variable name
+	return "", false, "invalid reference ${" + expr + "}"
+}
+
+// variableName returns the leading identifier of a ${...} expression
+func variableName(expr string) string {
+	for i, r := range expr {
+		if !(r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || i > 0 && r >= '0' && r <= '9') {
+			return expr[:i]
+		}
+	}
+	return expr
+}
+
+// RenderConfigFile reads a config file from configDir and renders it
+// with the .env values
+func RenderConfigFile(configDir string, cf ConfigFile, env map[string]string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(configDir, cf.Source))
+	if err != nil {
+		return nil, err
+	}
+	return RenderTemplate(cf.Source, data, env)
+}
+
+// FindConfigFile looks up a config file by its source name or its
+// destination in the data folder
+func FindConfigFile(name string) (ConfigFile, bool) {
+	base := filepath.Base(name)
+	for _, cf := range ConfigFiles {
+		if cf.Source == base || cf.Destination == name || filepath.ToSlash(name) == cf.Destination {
+			return cf, true
+		}
+	}
+	return ConfigFile{}, false
+}
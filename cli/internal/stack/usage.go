@@ -0,0 +1,123 @@
+package stack
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DirUsage is the measured size of a directory tree
+type DirUsage struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+	Error string `json:"error,omitempty"`
+}
+
+// DownloadDirectories returns the download client directories in
+// FOLDER_FOR_MEDIA, derived from MediaDirectories
+func DownloadDirectories() []string {
+	var dirs []string
+	for _, dir := range MediaDirectories {
+		if strings.HasPrefix(dir, "torrents/") || strings.HasPrefix(dir, "usenet/") || dir == "watch" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// MeasureDirectories walks each directory under root concurrently, at most
+// parallel at a time, and returns the results sorted by size (largest first).
+// Missing directories are reported with zero size.
+func MeasureDirectories(root string, dirs []string, parallel int) []DirUsage {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]DirUsage, len(dirs))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func(i int, dir string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			path := filepath.Join(root, dir)
+			usage := DirUsage{Name: dir, Path: path}
+
+			size, files, err := DirSize(path)
+			usage.Size = size
+			usage.Files = files
+			if err != nil && !os.IsNotExist(err) {
+				usage.Error = err.Error()
+			}
+			results[i] = usage
+		}(i, dir)
+	}
+
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Size != results[j].Size {
+			return results[i].Size > results[j].Size
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	return results
+}
+
+// DirSize returns the total size and number of regular files under path.
+// Hard-linked files are only counted once. Unreadable entries are skipped
+// and the first such error is returned alongside the partial totals.
+func DirSize(path string) (int64, int64, error) {
+	var size, files int64
+	var firstErr error
+	seen := make(map[fileID]bool)
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		}
+
+		if id, ok := hardLinkID(info); ok {
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+		}
+
+		size += info.Size()
+		files++
+		return nil
+	})
+	if err != nil {
+		return size, files, err
+	}
+
+	return size, files, firstErr
+}